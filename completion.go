@@ -0,0 +1,564 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PUM_HEIGHT is the most items the completion menu shows at once, and
+// PUM_DOC_WIDTH the width of the documentation preview beside it.
+const (
+	PUM_HEIGHT    = 10
+	PUM_DOC_WIDTH = 50
+)
+
+const (
+	COMPLETE_KEYWORD = iota
+	COMPLETE_FILE
+	COMPLETE_OMNI
+)
+
+// completionItem is a candidate of the completion menu. word is what gets
+// inserted, filter what the typed text is matched against.
+type completionItem struct {
+	word   string
+	filter string
+	kind   string
+	detail string
+	doc    string
+}
+
+// popupMenu is the completion menu shown under the cursor in insert mode.
+// items are all the candidates and shown those matching the typed text,
+// which runs from column start of the cursor line to the cursor.
+type popupMenu struct {
+	open   bool
+	source int
+	title  string
+	start  int
+	items  []completionItem
+	shown  []completionItem
+	sel    int
+	top    int
+}
+
+// editorComplete completes the text before the cursor from source, for
+// Ctrl-N, Ctrl-P, Ctrl-X Ctrl-F and Ctrl-X Ctrl-O in insert mode. Typing
+// narrows the menu, Ctrl-N and Ctrl-P select, Enter, Tab or Ctrl-Y insert the
+// selection and Ctrl-E closes it; any other key closes it and is handled as
+// usual.
+func editorComplete(source int, key rune) {
+	if goedit.cursor.y >= goedit.numOfRows {
+		goedit.insertRow(goedit.numOfRows, "")
+	}
+
+	p := &goedit.popup
+	*p = popupMenu{open: true, source: source, start: completionStart(source)}
+	msg := ""
+	defer func() {
+		goedit.popup = popupMenu{}
+		if msg != "" && goedit.editormsg.msg == msg {
+			editorMessage("-- INSERT --")
+		}
+	}()
+
+	switch source {
+	case COMPLETE_FILE:
+		p.title = "File name completion (^F^N^P)"
+	case COMPLETE_OMNI:
+		p.title = "Omni completion (^O^N^P)"
+	default:
+		p.title = "Keyword completion (^N^P)"
+	}
+
+	items, err := completionItems(source, key == CTRL_P)
+	if err != nil {
+		editorError(err)
+		return
+	}
+	p.items = items
+	p.filterItems()
+
+	switch {
+	case len(p.shown) == 0:
+		editorError(fmt.Errorf("%s: Pattern not found", p.title))
+		return
+	case len(p.shown) == 1:
+		insertCompletion(p.shown[0].word)
+		return
+	}
+
+	for {
+		msg = fmt.Sprintf("-- %s match %d of %d", p.title, p.sel+1, len(p.shown))
+		editorMessage(msg)
+		clearScreen()
+
+		key := readKey()
+		switch {
+		case key == CTRL_N || key == CURSOR_DOWN:
+			p.sel = (p.sel + 1) % len(p.shown)
+		case key == CTRL_P || key == CURSOR_UP:
+			p.sel = (p.sel + len(p.shown) - 1) % len(p.shown)
+		case key == '\r' || key == '\t' || key == CTRL_Y:
+			insertCompletion(p.shown[p.sel].word)
+			return
+		case key == CTRL_E:
+			return
+		case key == BACKSPACE:
+			if goedit.cursor.x <= p.start {
+				pushTypeahead([]typedKey{{key: key, noremap: true}})
+				return
+			}
+			goedit.rows[goedit.cursor.y].deleteRune(goedit.cursor.x - 1)
+			goedit.cursor.x--
+			if len(prevCharacters) > 0 {
+				prevCharacters = prevCharacters[:len(prevCharacters)-1]
+			}
+			if !p.refilter() {
+				return
+			}
+		case key < 0x80 && completionChar(source, byte(key)):
+			editorInsertRune(key)
+			prevCharacters = append(prevCharacters, key)
+			if !p.refilter() {
+				return
+			}
+		default:
+			pushTypeahead([]typedKey{{key: key, noremap: true}})
+			return
+		}
+	}
+}
+
+// completionChar reports whether c is part of the text source completes.
+func completionChar(source int, c byte) bool {
+	if source == COMPLETE_FILE {
+		return c > ' ' && !strings.ContainsRune("\"'`()[]{}<>,;=", rune(c))
+	}
+
+	return isKeywordChar(c)
+}
+
+// completionStart returns the column where the text before the cursor that
+// source completes starts.
+func completionStart(source int) int {
+	text := goedit.rows[goedit.cursor.y].text()
+	x := goedit.cursor.x
+	if x > len(text) {
+		x = len(text)
+	}
+
+	for x > 0 && completionChar(source, text[x-1]) {
+		x--
+	}
+
+	return x
+}
+
+// query returns the text typed so far, which the items are filtered by.
+func (p *popupMenu) query() string {
+	return goedit.rows[goedit.cursor.y].text()[p.start:goedit.cursor.x]
+}
+
+// filterItems shows the items matching the typed text, best matches first.
+func (p *popupMenu) filterItems() {
+	query := p.query()
+	if p.source == COMPLETE_FILE {
+		query = query[strings.LastIndex(query, "/")+1:]
+	}
+
+	type scored struct {
+		item  completionItem
+		score int
+	}
+
+	var matches []scored
+	for _, item := range p.items {
+		if score, ok := fuzzyScore(item.filter, query); ok {
+			matches = append(matches, scored{item, score})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score < matches[j].score })
+	p.shown = p.shown[:0]
+	for _, m := range matches {
+		p.shown = append(p.shown, m.item)
+	}
+	p.sel, p.top = 0, 0
+}
+
+// refilter filters the items again after the typed text changed, listing
+// the directory again for file names. It reports false when the text no
+// longer matches any item and the menu should close.
+func (p *popupMenu) refilter() bool {
+	if p.source == COMPLETE_FILE {
+		p.items = fileItems(p.query())
+	}
+
+	p.filterItems()
+	return len(p.shown) > 0
+}
+
+// insertCompletion replaces the typed text with word.
+func insertCompletion(word string) {
+	p := &goedit.popup
+	row := &goedit.rows[goedit.cursor.y]
+	text := row.text()
+	query := text[p.start:goedit.cursor.x]
+
+	row.setChars(text[:p.start] + word + text[goedit.cursor.x:])
+	goedit.cursor.x = p.start + len(word)
+	goedit.modifiyed = true
+
+	typed := []rune(query)
+	if n := len(prevCharacters) - len(typed); n >= 0 && string(prevCharacters[n:]) == query {
+		prevCharacters = prevCharacters[:n]
+	}
+	prevCharacters = append(prevCharacters, []rune(word)...)
+}
+
+// fuzzyScore reports whether the characters of query appear in word in
+// order, ignoring case when query is all lower case, and scores the match:
+// the lower the better, prefixes and runs of adjacent characters scoring
+// best.
+func fuzzyScore(word string, query string) (int, bool) {
+	if strings.ToLower(query) == query {
+		word = strings.ToLower(word)
+	}
+
+	score, last, j := 0, -1, 0
+	for i := 0; i < len(word) && j < len(query); i++ {
+		if word[i] != query[j] {
+			continue
+		}
+
+		if last < 0 {
+			score += 2 * i
+		} else {
+			score += i - last - 1
+		}
+		last = i
+		j++
+	}
+
+	return score, j == len(query)
+}
+
+// completionItems gathers the candidates of source for the text before the
+// cursor. Keywords are ordered by their distance from the cursor, looking
+// back first when backward is set.
+func completionItems(source int, backward bool) ([]completionItem, error) {
+	switch source {
+	case COMPLETE_FILE:
+		return fileItems(goedit.popup.query()), nil
+	case COMPLETE_OMNI:
+		return omniItems()
+	}
+
+	return keywordItems(backward), nil
+}
+
+// keywordItems returns the words of the buffer other than the one being
+// typed, nearest to the cursor first.
+func keywordItems(backward bool) []completionItem {
+	var items []completionItem
+	seen := map[string]bool{}
+	n := goedit.numOfRows
+	for i := 0; i < n; i++ {
+		y := (goedit.cursor.y + i) % n
+		if backward {
+			y = (goedit.cursor.y - i + n) % n
+		}
+
+		text := goedit.rows[y].text()
+		for x := 0; x < len(text); {
+			if !isKeywordChar(text[x]) {
+				x++
+				continue
+			}
+
+			end := x
+			for end < len(text) && isKeywordChar(text[end]) {
+				end++
+			}
+
+			word := text[x:end]
+			if !seen[word] && len(word) > 1 && !(y == goedit.cursor.y && x == goedit.popup.start) {
+				seen[word] = true
+				items = append(items, completionItem{word: word, filter: word})
+			}
+			x = end
+		}
+	}
+
+	return items
+}
+
+// fileItems returns the entries of the directory named by the text before
+// the last slash of query, directories ending in a slash. Hidden entries are
+// left out unless query names one.
+func fileItems(query string) []completionItem {
+	dir, base := "", query
+	if i := strings.LastIndex(query, "/"); i >= 0 {
+		dir, base = query[:i+1], query[i+1:]
+	}
+
+	path := dir
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[2:])
+		}
+	}
+	if path == "" {
+		path = "."
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil
+	}
+
+	var items []completionItem
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+
+		item := completionItem{word: dir + name, filter: name, kind: "f"}
+		if e.IsDir() {
+			item.word += "/"
+			item.kind = "d"
+		}
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// omniItems asks the language server for completions when one is running,
+// and otherwise type checks the package to list the fields, methods or
+// package members after a dot, or the names in scope.
+func omniItems() ([]completionItem, error) {
+	if !strings.HasSuffix(goedit.filename, ".go") {
+		return nil, fmt.Errorf("Omni completion needs a Go file")
+	}
+
+	if goedit.lsp != nil {
+		if items, err := lspItems(); err == nil && len(items) > 0 {
+			return items, nil
+		}
+	}
+
+	src := goedit.rowsToString()
+	pkg, err := checkPackage(goedit.filename, src)
+	if err != nil {
+		return nil, err
+	}
+
+	off := bufferOffset(goedit.cursor.y, goedit.popup.start)
+
+	var objs []types.Object
+	if line := goedit.rows[goedit.cursor.y].text(); goedit.popup.start > 0 && line[goedit.popup.start-1] == '.' {
+		found, ok := pkg.selectorMembers(off - 1)
+		if !ok {
+			return nil, nil
+		}
+		objs = found
+	} else {
+		objs = pkg.scopeNames(pkg.pos(off))
+	}
+
+	var items []completionItem
+	for _, obj := range objs {
+		items = append(items, completionItem{
+			word:   obj.Name(),
+			filter: obj.Name(),
+			kind:   objectKind(obj),
+			detail: objectString(obj, pkg.pkg),
+		})
+	}
+
+	return items, nil
+}
+
+// lspItems returns the language server's completions at the cursor.
+func lspItems() ([]completionItem, error) {
+	found, err := lspComplete()
+	if err != nil {
+		return nil, err
+	}
+
+	var items []completionItem
+	for _, c := range found {
+		word := c.Label
+		if c.TextEdit != nil {
+			word = c.TextEdit.NewText
+		} else if c.InsertText != "" {
+			word = c.InsertText
+		}
+
+		items = append(items, completionItem{
+			word:   word,
+			filter: c.Label,
+			kind:   lspKind(c.Kind),
+			detail: c.Detail,
+			doc:    hoverText(c.Documentation),
+		})
+	}
+
+	return items, nil
+}
+
+// lspKind maps an LSP completion item kind to the letter the menu shows.
+func lspKind(kind int) string {
+	switch kind {
+	case 2, 3, 4:
+		return "f"
+	case 5, 10:
+		return "m"
+	case 6:
+		return "v"
+	case 7, 8, 13, 22, 25:
+		return "t"
+	case 9:
+		return "p"
+	case 14:
+		return "k"
+	case 21:
+		return "c"
+	}
+
+	return ""
+}
+
+// drawPopup draws the completion menu over the text below the cursor, or
+// above it when there is more room there, with the documentation of the
+// selected item beside it.
+func drawPopup() {
+	p := &goedit.popup
+	if len(p.shown) == 0 {
+		return
+	}
+
+	row, col := goedit.cursor.y-goedit.rowOffSet, goedit.rx-goedit.colOffSet
+	if goedit.wrap {
+		row, col = goedit.cursorScreenRow(), goedit.cursorScreenCol()
+	}
+	line := goedit.rows[goedit.cursor.y]
+	// The items have a blank before them, which goes left of the text.
+	col += goedit.lineNumOffSet - (goedit.rx - cursorxToRx(line, p.start)) - 1
+	if col < 0 {
+		col = 0
+	}
+
+	height := len(p.shown)
+	if height > PUM_HEIGHT {
+		height = PUM_HEIGHT
+	}
+	top := row + 1
+	if below, above := goedit.height-row-1, row; height > below && above > below {
+		if height > above {
+			height = above
+		}
+		top = row - height
+	} else if height > below {
+		height = below
+	}
+	if height <= 0 {
+		return
+	}
+
+	if p.sel < p.top {
+		p.top = p.sel
+	}
+	if p.sel >= p.top+height {
+		p.top = p.sel - height + 1
+	}
+
+	word, kind := 0, 0
+	for _, item := range p.shown {
+		if len(item.word) > word {
+			word = len(item.word)
+		}
+		if len(item.kind) > kind {
+			kind = len(item.kind)
+		}
+	}
+
+	width := word + 2
+	if kind > 0 {
+		width += kind + 1
+	}
+	if width > goedit.width {
+		width = goedit.width
+	}
+	if col+width > goedit.width {
+		col = goedit.width - width
+	}
+
+	for i := 0; i < height; i++ {
+		item := p.shown[p.top+i]
+		text := fmt.Sprintf(" %-*s", word, item.word)
+		if kind > 0 {
+			text += fmt.Sprintf(" %-*s", kind, item.kind)
+		}
+		text += " "
+		if len(text) > width {
+			text = text[:width]
+		}
+
+		bg := WHITE + 10
+		if p.top+i == p.sel {
+			bg = CYAN + 10
+		}
+		goedit.editorUI.WriteString(fmt.Sprintf("\x1b[%d;%dH\x1b[%d;%dm%s\x1b[39;49m", top+i+1, col+1, BLACK, bg, text))
+	}
+
+	drawPreview(p.shown[p.sel], top, col+width)
+}
+
+// drawPreview draws the detail and documentation of item in a box at screen
+// row top and column col, when there is room for it.
+func drawPreview(item completionItem, top int, col int) {
+	width := goedit.width - col
+	if width > PUM_DOC_WIDTH {
+		width = PUM_DOC_WIDTH
+	}
+	if width < 10 {
+		return
+	}
+
+	var lines []string
+	for _, text := range []string{item.detail, item.doc} {
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+
+		for _, line := range strings.Split(text, "\n") {
+			for len(line) > width-2 {
+				lines = append(lines, line[:width-2])
+				line = line[width-2:]
+			}
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	for i, line := range lines {
+		if i >= PUM_HEIGHT || top+i >= goedit.height {
+			break
+		}
+		text := fmt.Sprintf(" %-*s ", width-2, strings.Replace(line, "\t", " ", -1))
+		goedit.editorUI.WriteString(fmt.Sprintf("\x1b[%d;%dH\x1b[%d;%dm%s\x1b[39;49m", top+i+1, col+1, WHITE, BLUE+10, text))
+	}
+}
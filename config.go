@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MAX_SOURCE_DEPTH is how deeply :source may nest, so that a file sourcing
+// itself fails instead of looping forever.
+const MAX_SOURCE_DEPTH = 50
+
+// sourceDepth counts the files being sourced.
+var sourceDepth int
+
+// defaultConfigFile returns $XDG_CONFIG_HOME/goedit/config, falling back to
+// ~/.config/goedit/config.
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "goedit", "config")
+}
+
+// loadConfig sources the config file given with -u, or the default one when
+// it exists. "NONE" skips loading a config file altogether. The error is
+// returned for main to report once the file to edit is open, as opening it
+// shows a message of its own.
+func loadConfig(filename string) error {
+	switch filename {
+	case "NONE":
+		return nil
+	case "":
+		filename = defaultConfigFile()
+		if _, err := os.Stat(filename); err != nil {
+			return nil
+		}
+	}
+
+	return sourceFile(filename)
+}
+
+// sourceFile runs every line of filename as a : command. Blank lines and lines
+// starting with a double quote are ignored. Errors do not stop the remaining
+// lines from running; the first one is returned along with its location.
+func sourceFile(filename string) error {
+	if sourceDepth >= MAX_SOURCE_DEPTH {
+		return exErr(169, "Command too recursive", "")
+	}
+	sourceDepth++
+	defer func() { sourceDepth-- }()
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return exErr(484, "Can't open file", filename)
+	}
+	defer file.Close()
+
+	var first error
+	failed := 0
+	line := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "\"") {
+			continue
+		}
+
+		if err := executeCommand(text); err != nil {
+			if e, ok := err.(exError); ok && e.code == 169 {
+				return err
+			}
+			logger.Printf("%s:%d: %v", filename, line, err)
+			if first == nil {
+				first = fmt.Errorf("%s:%d: %v", filename, line, err)
+			}
+			failed++
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if failed > 1 {
+		return fmt.Errorf("%v (and %d more errors)", first, failed-1)
+	}
+
+	return first
+}
+
+// setLogFile points the logger at filename, or discards what is logged when
+// filename is empty, as it is until the logfile option is set.
+func setLogFile(filename string) error {
+	var file *os.File
+	if filename != "" {
+		var err error
+		if file, err = os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666); err != nil {
+			return err
+		}
+	}
+
+	if errorlog != nil {
+		errorlog.Close()
+	}
+
+	errorlog = file
+	logger = log.New(ioutil.Discard, "", 0)
+	if file != nil {
+		logger = log.New(errorlog, "goedit: ", log.Lshortfile|log.LstdFlags)
+	}
+	goedit.logFile = filename
+
+	return nil
+}
+
+var colorNames = map[string]int{
+	"black":   BLACK,
+	"red":     RED,
+	"green":   GREEN,
+	"yellow":  YELLOW,
+	"blue":    BLUE,
+	"magenta": MAGENTA,
+	"cyan":    CYAN,
+	"white":   WHITE,
+}
+
+// parseColor accepts a color name or an SGR foreground code.
+func parseColor(value string) (int, error) {
+	if c, ok := colorNames[strings.ToLower(value)]; ok {
+		return c, nil
+	}
+
+	var c int
+	if _, err := fmt.Sscanf(value, "%d", &c); err != nil || c < BLACK || c > WHITE {
+		return 0, exErr(254, "Cannot allocate color", value)
+	}
+
+	return c, nil
+}
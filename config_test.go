@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigWinsOverSyntax(t *testing.T) {
+	defer initOptions()
+	initOptions()
+
+	// go.json sets tabstop and shiftwidth to 4 and noexpandtab.
+	for _, arg := range []string{"syntaxdir=.", "ts=3", "et"} {
+		if _, err := setOption(arg, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	selectSyntax("x.go")
+	if got := goedit.indent; got.TabStop != 3 || !got.ExpandTab || got.ShiftWidth != 4 {
+		t.Errorf("indent = %+v, want ts=3 et from :set and sw=4 from go.json", got)
+	}
+
+	setOption("ts&", false)
+	selectSyntax("x.go")
+	if goedit.indent.TabStop != 4 {
+		t.Errorf("tabstop after ts& = %d, want go.json's 4", goedit.indent.TabStop)
+	}
+}
+
+func TestSourceRecursion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goedit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(filename, []byte("source "+filename+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = sourceFile(filename)
+	if e, ok := err.(exError); !ok || e.code != 169 {
+		t.Errorf("sourcing a file that sources itself = %v, want E169", err)
+	}
+	if sourceDepth != 0 {
+		t.Errorf("sourceDepth = %d after sourcing, want 0", sourceDepth)
+	}
+}
+
+func TestLogFileOnlyWhenSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goedit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	defer initOptions()
+	defer setLogFile("")
+
+	initOptions()
+	logger.Println("discarded")
+	if names, _ := filepath.Glob("*"); len(names) != 0 {
+		t.Errorf("logging without logfile made %v", names)
+	}
+
+	if _, err := setOption("logfile=x.log", false); err != nil {
+		t.Fatal(err)
+	}
+	logger.Println("kept")
+	if log, _ := ioutil.ReadFile("x.log"); !strings.Contains(string(log), "kept") || strings.Contains(string(log), "discarded") {
+		t.Errorf("x.log = %q, want only the message logged after :set logfile", log)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SIGN_WIDTH is the width of the sign column drawn left of the line numbers
+// while the buffer has diagnostics.
+const SIGN_WIDTH = 2
+
+// diagnostic is an error or warning shown on row y of the buffer, from the
+// language server or from the last build. start and end are byte columns.
+type diagnostic struct {
+	y, start, end int
+	kind          byte
+	text          string
+}
+
+func (d diagnostic) String() string {
+	return fmt.Sprintf("%s: %s", qfEntry{kind: d.kind}.kindName(), d.text)
+}
+
+// rank orders diagnostics by severity, errors first.
+func (d diagnostic) rank() int {
+	return strings.IndexByte("EWIN", d.kind)
+}
+
+// color is the color of the sign and virtual text of d.
+func (d diagnostic) color() int {
+	switch d.kind {
+	case 'W':
+		return YELLOW
+	case 'I':
+		return BLUE
+	case 'N':
+		return CYAN
+	}
+
+	return RED
+}
+
+// updateDiagnostics collects the diagnostics for the buffer in goedit.signs,
+// in buffer order, and makes room for the sign column when there are any.
+func updateDiagnostics() {
+	var signs []diagnostic
+	seen := map[diagnostic]bool{}
+	add := func(d diagnostic) {
+		if d.y < 0 || d.y >= goedit.numOfRows || seen[d] {
+			return
+		}
+		if d.end <= d.start {
+			d.end = d.start + 1
+		}
+		seen[d] = true
+		signs = append(signs, d)
+	}
+
+	for _, d := range bufferDiagnostics() {
+		y := d.Range.Start.Line
+		if y < 0 || y >= goedit.numOfRows {
+			continue
+		}
+
+		line := goedit.rows[y].text()
+		end := len(line)
+		if d.Range.End.Line == y {
+			end = byteCol(line, d.Range.End.Character)
+		}
+		add(diagnostic{y: y, start: byteCol(line, d.Range.Start.Character), end: end, kind: diagnosticKind(d.Severity), text: d.Message})
+	}
+
+	if goedit.quickfix.build && goedit.filename != "" {
+		for _, e := range goedit.quickfix.entries {
+			if !e.valid || e.line < 1 || e.line > goedit.numOfRows || !sameFile(e.filename, goedit.filename) {
+				continue
+			}
+
+			kind := e.kind
+			if strings.IndexByte("EWIN", kind) < 0 {
+				kind = 'E'
+			}
+
+			d := diagnostic{y: e.line - 1, kind: kind, text: e.text}
+			line := goedit.rows[d.y].text()
+			if e.col > 0 {
+				d.start = e.col - 1
+				d.end = wordEnd(line, d.start)
+			} else {
+				d.start, d.end = len(line)-len(strings.TrimLeft(line, " \t")), len(line)
+			}
+			add(d)
+		}
+	}
+
+	sort.SliceStable(signs, func(i, j int) bool {
+		if signs[i].y != signs[j].y {
+			return signs[i].y < signs[j].y
+		}
+		return signs[i].start < signs[j].start
+	})
+
+	goedit.signs = signs
+	goedit.signWidth = 0
+	if len(signs) > 0 {
+		goedit.signWidth = SIGN_WIDTH
+	}
+	goedit.updateGutter()
+}
+
+// wordEnd returns the end of the word starting at x in line, or x+1 when
+// there is no word there.
+func wordEnd(line string, x int) int {
+	end := x
+	for end < len(line) && isKeywordChar(line[end]) {
+		end++
+	}
+
+	if end == x {
+		return x + 1
+	}
+	return end
+}
+
+// rowSigns groups goedit.signs by row, the most severe first.
+func rowSigns() map[int][]diagnostic {
+	rows := map[int][]diagnostic{}
+	for _, d := range goedit.signs {
+		rows[d.y] = append(rows[d.y], d)
+	}
+
+	for _, ds := range rows {
+		sort.SliceStable(ds, func(i, j int) bool { return ds[i].rank() < ds[j].rank() })
+	}
+
+	return rows
+}
+
+// diagnosticRegions returns the render columns of row that diags underlines.
+func diagnosticRegions(row erow, diags []diagnostic) []matchRegion {
+	var regions []matchRegion
+	for _, d := range diags {
+		regions = append(regions, matchRegion{start: cursorxToRx(row, d.start), end: cursorxToRx(row, d.end)})
+	}
+
+	return regions
+}
+
+// drawSign draws the sign column for a row with diags.
+func drawSign(diags []diagnostic) {
+	if len(diags) == 0 {
+		goedit.editorUI.WriteString(strings.Repeat(" ", goedit.signWidth))
+		return
+	}
+
+	d := diags[0]
+	goedit.editorUI.WriteString(fmt.Sprintf("\x1b[1;%dm%c\x1b[22;39m", d.color(), d.kind))
+	goedit.editorUI.WriteString(strings.Repeat(" ", goedit.signWidth-1))
+}
+
+// drawVirtualText draws the message of the most severe of diags after the
+// text of a row, in the room left of width columns.
+func drawVirtualText(diags []diagnostic, width int) {
+	if len(diags) == 0 || width <= 2 {
+		return
+	}
+
+	d := diags[0]
+	text := "  " + strings.Replace(d.text, "\n", " ", -1)
+	if len(text) > width {
+		text = text[:width]
+	}
+	goedit.editorUI.WriteString(fmt.Sprintf("\x1b[%dm%s\x1b[39m", d.color(), text))
+}
+
+// editorJumpDiagnostic implements ]d and [d, moving to the next diagnostic
+// in direction dir, wrapping around the buffer when wrapscan is set.
+func editorJumpDiagnostic(dir int) error {
+	updateDiagnostics()
+	if len(goedit.signs) == 0 {
+		return fmt.Errorf("No diagnostics")
+	}
+
+	after := func(d diagnostic) bool {
+		return d.y > goedit.cursor.y || d.y == goedit.cursor.y && d.start > goedit.cursor.x
+	}
+	before := func(d diagnostic) bool {
+		return d.y < goedit.cursor.y || d.y == goedit.cursor.y && d.start < goedit.cursor.x
+	}
+
+	found := -1
+	if dir > 0 {
+		for i, d := range goedit.signs {
+			if after(d) {
+				found = i
+				break
+			}
+		}
+		if found < 0 && goedit.wrapScan {
+			found = 0
+		}
+	} else {
+		for i := len(goedit.signs) - 1; i >= 0; i-- {
+			if before(goedit.signs[i]) {
+				found = i
+				break
+			}
+		}
+		if found < 0 && goedit.wrapScan {
+			found = len(goedit.signs) - 1
+		}
+	}
+
+	if found < 0 {
+		if dir > 0 {
+			return fmt.Errorf("No more diagnostics below")
+		}
+		return fmt.Errorf("No more diagnostics above")
+	}
+
+	d := goedit.signs[found]
+	goedit.cursor = cursor{x: d.start, y: d.y}
+	goedit.moveCursor(0)
+	goedit.diagMsg = d.String()
+	editorMessage(goedit.diagMsg)
+	return nil
+}
+
+// showCursorDiagnostic shows the diagnostic of the cursor line in the message
+// bar, unless the bar holds some other message, and clears it again once the
+// cursor leaves the line.
+func showCursorDiagnostic() {
+	if goedit.mode != NORMAL_MODE || goedit.qfWin.focus {
+		return
+	}
+
+	var best *diagnostic
+	for i, d := range goedit.signs {
+		if d.y == goedit.cursor.y && (best == nil || d.rank() < best.rank()) {
+			best = &goedit.signs[i]
+		}
+	}
+
+	msg := ""
+	if best != nil {
+		msg = best.String()
+	}
+
+	current := goedit.editormsg.msg
+	switch {
+	case msg != "" && (current == "" || current == goedit.diagMsg):
+		goedit.diagMsg = msg
+		editorMessage(msg)
+	case msg == "" && current != "" && current == goedit.diagMsg:
+		goedit.diagMsg = ""
+		editorMessage("")
+	}
+}
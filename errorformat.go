@@ -0,0 +1,182 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ERROR_FORMAT understands the output of go build, go vet, go test,
+// staticcheck, golangci-lint and grep -n.
+const ERROR_FORMAT = `vet: %f:%l:%c: %m,%f:%l:%c: %m,%f:%l:%m,%-G#%.%#`
+
+// errorFormat is one pattern of an errorformat option turned into a regexp,
+// fields naming what each of its groups holds.
+type errorFormat struct {
+	re     *regexp.Regexp
+	fields []byte
+	ignore bool
+}
+
+// splitErrorFormat splits an errorformat option on the commas not escaped
+// by a backslash.
+func splitErrorFormat(efm string) []string {
+	var parts []string
+	buf := strings.Builder{}
+	for i := 0; i < len(efm); i++ {
+		switch {
+		case efm[i] == '\\' && i+1 < len(efm) && efm[i+1] == ',':
+			buf.WriteByte(',')
+			i++
+		case efm[i] == ',':
+			parts = append(parts, buf.String())
+			buf.Reset()
+		default:
+			buf.WriteByte(efm[i])
+		}
+	}
+
+	return append(parts, buf.String())
+}
+
+// parseErrorFormat compiles efm, a comma separated list of patterns as in
+// vim. A pattern matches a whole line, where %f is a file name, %l a line
+// number, %c a column, %t the error type (e, w, i or n), %m the message, %.
+// any character, %# a repeat of what precedes it and %% a percent sign. A
+// pattern starting with %-G drops the lines it matches.
+func parseErrorFormat(efm string) ([]errorFormat, error) {
+	var formats []errorFormat
+	for _, part := range splitErrorFormat(efm) {
+		if part == "" {
+			continue
+		}
+
+		f := errorFormat{}
+		if strings.HasPrefix(part, "%-G") {
+			f.ignore = true
+			part = part[3:]
+		}
+
+		buf := strings.Builder{}
+		buf.WriteString("^")
+		for i := 0; i < len(part); i++ {
+			ch := part[i]
+			if ch == '\\' && i+1 < len(part) {
+				buf.WriteString(regexp.QuoteMeta(part[i+1 : i+2]))
+				i++
+				continue
+			}
+
+			if ch != '%' {
+				buf.WriteString(regexp.QuoteMeta(string(ch)))
+				continue
+			}
+
+			if i+1 >= len(part) {
+				return nil, exErr(372, "Too many % in format string", part)
+			}
+
+			i++
+			switch part[i] {
+			case 'f':
+				buf.WriteString(`\s*(.+?)`)
+			case 'l', 'c':
+				buf.WriteString(`(\d+)`)
+			case 't':
+				buf.WriteString(`([EeWwIiNn])`)
+			case 'm':
+				buf.WriteString(`(.*)`)
+			case '.':
+				buf.WriteString(".")
+			case '#':
+				buf.WriteString("*")
+			case '%':
+				buf.WriteString("%")
+			case '\\':
+				if i+1 < len(part) {
+					buf.WriteString(`\` + part[i+1:i+2])
+					i++
+				}
+				continue
+			default:
+				return nil, exErr(373, "Unexpected % in format string", part[i-1:i+1])
+			}
+
+			if strings.IndexByte("flctm", part[i]) >= 0 {
+				f.fields = append(f.fields, part[i])
+			}
+		}
+		buf.WriteString("$")
+
+		re, err := regexp.Compile(buf.String())
+		if err != nil {
+			return nil, exErr(373, "Unexpected % in format string", part)
+		}
+		f.re = re
+
+		formats = append(formats, f)
+	}
+
+	return formats, nil
+}
+
+// parseErrorLines turns tool output into quickfix entries. Lines matching no
+// format are kept as text-only entries that cannot be jumped to, as in vim.
+func parseErrorLines(formats []errorFormat, lines []string) []qfEntry {
+	var entries []qfEntry
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		e, ignore := qfEntry{text: strings.TrimSpace(line)}, false
+		for _, f := range formats {
+			m := f.re.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+
+			if ignore = f.ignore; ignore {
+				break
+			}
+
+			e = qfEntry{}
+			for i, field := range f.fields {
+				value := m[i+1]
+				switch field {
+				case 'f':
+					e.filename = value
+				case 'l':
+					e.line, _ = strconv.Atoi(value)
+				case 'c':
+					e.col, _ = strconv.Atoi(value)
+				case 't':
+					e.kind = strings.ToUpper(value)[0]
+				case 'm':
+					e.text = strings.TrimSpace(value)
+				}
+			}
+			e.valid = e.filename != "" || e.line > 0
+			break
+		}
+
+		if ignore {
+			continue
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries
+}
+
+// errorEntries parses lines with the errorformat option.
+func errorEntries(lines []string) ([]qfEntry, error) {
+	formats, err := parseErrorFormat(goedit.errorFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseErrorLines(formats, lines), nil
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	EX_RANGE     = 1 << iota // accepts a line range, defaulting to the cursor line
+	EX_WHOLE                 // the range defaults to the whole buffer
+	EX_ZERO                  // line 0 is a valid address
+	EX_BANG                  // accepts a ! after the name
+	EX_BAR_ARG               // | is part of the argument rather than a separator
+	EX_SHELL_ARG             // | is part of an argument starting with !, a shell command
+)
+
+// exError is an error from parsing or running an Ex command, shown in the
+// message bar as "E<code>: <msg>: <arg>".
+type exError struct {
+	code int
+	msg  string
+	arg  string
+}
+
+func (e exError) Error() string {
+	if e.arg == "" {
+		return fmt.Sprintf("E%d: %s", e.code, e.msg)
+	}
+
+	return fmt.Sprintf("E%d: %s: %s", e.code, e.msg, e.arg)
+}
+
+func exErr(code int, msg string, arg string) error {
+	return exError{code: code, msg: msg, arg: arg}
+}
+
+// exCommand describes an Ex command. It can be abbreviated down to its first
+// abbr characters.
+type exCommand struct {
+	name  string
+	abbr  int
+	flags int
+	run   func(c *exCmd) error
+}
+
+// exCmd is a parsed invocation of an Ex command. line1 and line2 are 1-based
+// and inclusive; addrs is how many addresses were given.
+type exCmd struct {
+	def   *exCommand
+	name  string
+	line1 int
+	line2 int
+	addrs int
+	bang  bool
+	arg   string
+}
+
+// args splits the argument on blanks.
+func (c *exCmd) args() []string {
+	return strings.Fields(c.arg)
+}
+
+var exCommands []exCommand
+
+func init() {
+	exCommands = []exCommand{
+		{name: "substitute", abbr: 1, flags: EX_RANGE, run: exSubstitute},
+		{name: "global", abbr: 1, flags: EX_RANGE | EX_WHOLE | EX_BANG | EX_BAR_ARG, run: exGlobal},
+		{name: "vglobal", abbr: 1, flags: EX_RANGE | EX_WHOLE | EX_BAR_ARG, run: exGlobal},
+		{name: "normal", abbr: 4, flags: EX_RANGE | EX_BANG | EX_BAR_ARG, run: exNormal},
+		{name: "delete", abbr: 1, flags: EX_RANGE, run: exDelete},
+		{name: "move", abbr: 1, flags: EX_RANGE, run: exMove},
+		{name: "copy", abbr: 2, flags: EX_RANGE, run: exCopy},
+		{name: "t", abbr: 1, flags: EX_RANGE, run: exCopy},
+		{name: "join", abbr: 1, flags: EX_RANGE | EX_BANG, run: exJoin},
+		{name: ">", abbr: 1, flags: EX_RANGE, run: exShift},
+		{name: "<", abbr: 1, flags: EX_RANGE, run: exShift},
+		{name: "!", abbr: 1, flags: EX_RANGE | EX_BAR_ARG, run: exBang},
+		{name: "read", abbr: 1, flags: EX_RANGE | EX_ZERO | EX_SHELL_ARG, run: exRead},
+		{name: "saveas", abbr: 3, flags: EX_BANG, run: exSaveas},
+		{name: "update", abbr: 2, flags: EX_RANGE | EX_WHOLE | EX_BANG, run: exWrite},
+		{name: "cnext", abbr: 2, flags: EX_BANG, run: exCnext},
+		{name: "cNext", abbr: 2, flags: EX_BANG, run: exCnext},
+		{name: "cprevious", abbr: 2, flags: EX_BANG, run: exCnext},
+		{name: "cc", abbr: 2, flags: EX_BANG, run: exCc},
+		{name: "copen", abbr: 4, run: exCopen},
+		{name: "cclose", abbr: 3, run: exCclose},
+		{name: "cfile", abbr: 2, flags: EX_BANG, run: exCfile},
+		{name: "cexpr", abbr: 3, flags: EX_BANG | EX_BAR_ARG, run: exCexpr},
+		{name: "edit", abbr: 1, flags: EX_BANG, run: exEdit},
+		{name: "grep", abbr: 2, flags: EX_BANG | EX_BAR_ARG, run: exGrep},
+		{name: "mark", abbr: 2, flags: EX_RANGE, run: exMark},
+		{name: "lnext", abbr: 3, flags: EX_BANG, run: exCnext},
+		{name: "lNext", abbr: 2, flags: EX_BANG, run: exCnext},
+		{name: "lprevious", abbr: 2, flags: EX_BANG, run: exCnext},
+		{name: "ll", abbr: 2, flags: EX_BANG, run: exCc},
+		{name: "lopen", abbr: 3, run: exCopen},
+		{name: "lclose", abbr: 3, run: exCclose},
+		{name: "lfile", abbr: 2, flags: EX_BANG, run: exCfile},
+		{name: "lexpr", abbr: 3, flags: EX_BANG | EX_BAR_ARG, run: exCexpr},
+		{name: "lgrep", abbr: 3, flags: EX_BANG | EX_BAR_ARG, run: exGrep},
+		{name: "lvimgrep", abbr: 2, flags: EX_BANG | EX_BAR_ARG, run: exVimgrep},
+		{name: "make", abbr: 3, flags: EX_BANG, run: exMake},
+		{name: "map", abbr: 3, flags: EX_BANG | EX_BAR_ARG, run: exMap},
+		{name: "nmap", abbr: 2, flags: EX_BAR_ARG, run: exMap},
+		{name: "noremap", abbr: 2, flags: EX_BANG | EX_BAR_ARG, run: exMap},
+		{name: "nnoremap", abbr: 2, flags: EX_BAR_ARG, run: exMap},
+		{name: "imap", abbr: 2, flags: EX_BAR_ARG, run: exMap},
+		{name: "inoremap", abbr: 3, flags: EX_BAR_ARG, run: exMap},
+		{name: "nunmap", abbr: 3, flags: EX_BAR_ARG, run: exUnmap},
+		{name: "iunmap", abbr: 2, flags: EX_BAR_ARG, run: exUnmap},
+		{name: "nohlsearch", abbr: 3, run: exNohlsearch},
+		{name: "open", abbr: 1, flags: EX_BANG, run: exEdit},
+		{name: "pclose", abbr: 2, run: exPclose},
+		{name: "quit", abbr: 1, flags: EX_BANG, run: exQuit},
+		{name: "set", abbr: 2, run: exSet},
+		{name: "setlocal", abbr: 4, run: exSet},
+		{name: "source", abbr: 2, run: exSource},
+		{name: "sort", abbr: 3, flags: EX_RANGE | EX_WHOLE | EX_BANG, run: exSort},
+		{name: "vimgrep", abbr: 3, flags: EX_BANG | EX_BAR_ARG, run: exVimgrep},
+		{name: "unmap", abbr: 3, flags: EX_BANG | EX_BAR_ARG, run: exUnmap},
+		{name: "Fmt", abbr: 3, run: exFmt},
+		{name: "GoImports", abbr: 9, run: exGoImports},
+		{name: "GoImport", abbr: 8, run: exGoImport},
+		{name: "GoDrop", abbr: 6, run: exGoDrop},
+		{name: "LspHover", abbr: 8, run: exLspHover},
+		{name: "LspDefinition", abbr: 13, run: exLspDefinition},
+		{name: "LspReferences", abbr: 13, run: exLspReferences},
+		{name: "LspRename", abbr: 9, run: exLspRename},
+		{name: "LspDiagnostics", abbr: 14, run: exLspDiagnostics},
+		{name: "GoBuild", abbr: 7, flags: EX_BANG, run: exGoBuild},
+		{name: "GoRun", abbr: 5, run: exGoRun},
+		{name: "GoTest", abbr: 6, run: exGoTest},
+		{name: "GoTestFunc", abbr: 10, run: exGoTestFunc},
+		{name: "GoStop", abbr: 6, run: exGoStop},
+		{name: "write", abbr: 1, flags: EX_RANGE | EX_WHOLE | EX_BANG | EX_SHELL_ARG, run: exWrite},
+		{name: "wq", abbr: 2, flags: EX_RANGE | EX_WHOLE | EX_BANG, run: exWrite},
+		{name: "xit", abbr: 1, flags: EX_RANGE | EX_WHOLE | EX_BANG, run: exWrite},
+	}
+}
+
+// lookupExCommand finds the command name is the full name or an accepted
+// abbreviation of. Commands earlier in the table win, as "s" does in vim.
+func lookupExCommand(name string) *exCommand {
+	for i, c := range exCommands {
+		if c.name == name {
+			return &exCommands[i]
+		}
+	}
+
+	for i, c := range exCommands {
+		if len(name) >= c.abbr && strings.HasPrefix(c.name, name) {
+			return &exCommands[i]
+		}
+	}
+
+	return nil
+}
+
+// executeCommand runs a : command line, as typed at the prompt or read from a
+// config file. Commands separated by | run in turn until one fails.
+func executeCommand(line string) error {
+	for {
+		c, rest, err := parseExCommand(line)
+		if err != nil {
+			return err
+		}
+
+		if c != nil {
+			if err := c.run(); err != nil {
+				return err
+			}
+		}
+
+		if rest == "" {
+			return nil
+		}
+		line = rest
+	}
+}
+
+func (c *exCmd) run() error {
+	if c.def == nil {
+		// A range on its own moves the cursor to its last line.
+		editorGotoLine(c.line2)
+		return nil
+	}
+
+	return c.def.run(c)
+}
+
+// parseExCommand parses the first command of line, returning it along with
+// whatever follows a | separator. It returns a nil command for an empty line.
+func parseExCommand(line string) (*exCmd, string, error) {
+	p := exParser{text: line}
+	p.skipBlanks()
+
+	c := &exCmd{}
+	if err := p.parseRange(c); err != nil {
+		return nil, "", err
+	}
+	p.skipBlanks()
+
+	start := p.pos
+	switch ch := p.peek(); {
+	case unicode.IsLetter(rune(ch)):
+		for p.pos < len(p.text) && unicode.IsLetter(rune(p.text[p.pos])) {
+			p.pos++
+		}
+	case ch == '<' || ch == '>' || ch == '!':
+		// The shift and shell commands are named by a single character;
+		// the shifts may repeat it in the argument.
+		p.pos++
+	}
+	c.name = p.text[start:p.pos]
+
+	if c.name == "" {
+		if p.pos < len(p.text) && p.text[p.pos] != '|' && p.text[p.pos] != '"' {
+			return nil, "", exErr(492, "Not an editor command", strings.TrimSpace(line))
+		}
+
+		rest := ""
+		if p.pos < len(p.text) && p.text[p.pos] == '|' {
+			rest = p.text[p.pos+1:]
+		}
+
+		if c.addrs == 0 {
+			return nil, rest, nil
+		}
+		return c, rest, nil
+	}
+
+	c.def = lookupExCommand(c.name)
+	if c.def == nil {
+		return nil, "", exErr(492, "Not an editor command", strings.TrimSpace(line))
+	}
+
+	if p.pos < len(p.text) && p.text[p.pos] == '!' && c.def.flags&EX_BANG != 0 {
+		c.bang = true
+		p.pos++
+	}
+
+	barInArg := c.def.flags&EX_BAR_ARG != 0
+	if c.def.flags&EX_SHELL_ARG != 0 && strings.HasPrefix(strings.TrimLeft(p.text[p.pos:], " \t"), "!") {
+		barInArg = true
+	}
+
+	arg, rest := splitBar(p.text[p.pos:], barInArg)
+	c.arg = strings.TrimSpace(arg)
+
+	if err := c.checkRange(); err != nil {
+		return nil, "", err
+	}
+
+	return c, rest, nil
+}
+
+// checkRange fills in the default range and validates the given one.
+func (c *exCmd) checkRange() error {
+	if c.def.flags&EX_RANGE == 0 {
+		if c.addrs > 0 {
+			return exErr(481, "No range allowed", "")
+		}
+		return nil
+	}
+
+	if c.addrs == 0 {
+		if c.def.flags&EX_WHOLE != 0 {
+			c.line1, c.line2 = 1, goedit.numOfRows
+		} else {
+			c.line1 = goedit.cursor.y + 1
+			c.line2 = c.line1
+		}
+	}
+
+	if goedit.numOfRows == 0 {
+		// An empty buffer has no lines to address beyond the first.
+		if c.line1 > 1 || c.line2 > 1 {
+			return exErr(16, "Invalid range", "")
+		}
+		c.line1, c.line2 = 1, 0
+		return nil
+	}
+
+	if c.line1 > c.line2 {
+		c.line1, c.line2 = c.line2, c.line1
+	}
+
+	if c.line1 < 0 || c.line2 > goedit.numOfRows {
+		return exErr(16, "Invalid range", "")
+	}
+
+	if c.def.flags&EX_ZERO == 0 && c.line1 == 0 && goedit.numOfRows > 0 {
+		c.line1 = 1
+		if c.line2 == 0 {
+			c.line2 = 1
+		}
+	}
+
+	return nil
+}
+
+// splitBar splits s at the first | not escaped by a backslash, unless the
+// command takes | as part of its argument.
+func splitBar(s string, barInArg bool) (string, string) {
+	if barInArg {
+		return s, ""
+	}
+
+	buf := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == '|':
+			buf.WriteByte('|')
+			i++
+		case s[i] == '|':
+			return buf.String(), s[i+1:]
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+
+	return buf.String(), ""
+}
+
+type exParser struct {
+	text string
+	pos  int
+}
+
+func (p *exParser) skipBlanks() {
+	for p.pos < len(p.text) && (p.text[p.pos] == ' ' || p.text[p.pos] == '\t' || p.text[p.pos] == ':') {
+		p.pos++
+	}
+}
+
+func (p *exParser) peek() byte {
+	if p.pos < len(p.text) {
+		return p.text[p.pos]
+	}
+
+	return 0
+}
+
+// parseRange parses "%", or addresses separated by "," or ";". After ";" the
+// cursor moves to the previous address before the next one is evaluated.
+func (p *exParser) parseRange(c *exCmd) error {
+	if p.peek() == '%' {
+		p.pos++
+		c.line1, c.line2, c.addrs = 1, goedit.numOfRows, 2
+		return nil
+	}
+
+	cur := goedit.cursor.y + 1
+	for {
+		p.skipBlanks()
+		line, ok, err := p.parseAddress(cur)
+		if err != nil {
+			return err
+		}
+
+		sep := p.peek()
+		if !ok {
+			if sep != ',' && sep != ';' {
+				return nil
+			}
+			line = cur
+		}
+
+		c.line1 = c.line2
+		c.line2 = line
+		c.addrs++
+		if c.addrs == 1 {
+			c.line1 = line
+		}
+
+		if sep != ',' && sep != ';' {
+			return nil
+		}
+
+		p.pos++
+		if sep == ';' {
+			cur = line
+		}
+	}
+}
+
+// parseAddress parses a single line address with any +N/-N offsets relative
+// to the line cur. ok is false when there is no address at p.
+func (p *exParser) parseAddress(cur int) (int, bool, error) {
+	line := cur
+	ok := true
+
+	switch ch := p.peek(); {
+	case ch == '.':
+		p.pos++
+	case ch == '$':
+		p.pos++
+		line = goedit.numOfRows
+	case ch >= '0' && ch <= '9':
+		line = p.parseNumber()
+	case ch == '\'':
+		if p.pos+1 >= len(p.text) {
+			return 0, false, exErr(20, "Mark not set", "")
+		}
+		mark, set := goedit.marks[rune(p.text[p.pos+1])]
+		if !set {
+			return 0, false, exErr(20, "Mark not set", "")
+		}
+		p.pos += 2
+		line = mark.y + 1
+	case ch == '/' || ch == '?':
+		pattern := p.parseDelimited(ch)
+		found, err := searchLine(pattern, cur, ch == '/')
+		if err != nil {
+			return 0, false, err
+		}
+		line = found
+	case ch == '+' || ch == '-':
+	default:
+		ok = false
+	}
+
+	for p.peek() == '+' || p.peek() == '-' {
+		sign := 1
+		if p.peek() == '-' {
+			sign = -1
+		}
+		p.pos++
+
+		n := 1
+		if ch := p.peek(); ch >= '0' && ch <= '9' {
+			n = p.parseNumber()
+		}
+		line += sign * n
+		ok = true
+	}
+
+	return line, ok, nil
+}
+
+func (p *exParser) parseNumber() int {
+	start := p.pos
+	for p.pos < len(p.text) && p.text[p.pos] >= '0' && p.text[p.pos] <= '9' {
+		p.pos++
+	}
+
+	n, _ := strconv.Atoi(p.text[start:p.pos])
+	return n
+}
+
+// parseDelimited reads text up to an unescaped delim, starting at the opening
+// delimiter, and consumes the closing one when present.
+func (p *exParser) parseDelimited(delim byte) string {
+	p.pos++
+	buf := strings.Builder{}
+	for p.pos < len(p.text) && p.text[p.pos] != delim {
+		if p.text[p.pos] == '\\' && p.pos+1 < len(p.text) && p.text[p.pos+1] == delim {
+			p.pos++
+		}
+		buf.WriteByte(p.text[p.pos])
+		p.pos++
+	}
+
+	if p.pos < len(p.text) {
+		p.pos++
+	}
+
+	return buf.String()
+}
+
+// searchLine finds the first line after (or before) line cur containing
+// pattern, wrapping around the buffer. An empty pattern reuses the last
+// search.
+func searchLine(pattern string, cur int, forward bool) (int, error) {
+	if pattern == "" {
+		pattern = goedit.search.query
+	}
+
+	if pattern == "" {
+		return 0, exErr(35, "No previous regular expression", "")
+	}
+	re, err := compilePattern(pattern)
+	if err != nil {
+		return 0, err
+	}
+	goedit.search.query = pattern
+
+	for i := 1; i <= goedit.numOfRows; i++ {
+		line := cur + i
+		if !forward {
+			line = cur - i
+		}
+		line = (line-1+goedit.numOfRows)%goedit.numOfRows + 1
+
+		if re.MatchString(goedit.rows[line-1].text()) {
+			return line, nil
+		}
+	}
+
+	return 0, exErr(486, "Pattern not found", pattern)
+}
+
+// editorGotoLine moves the cursor to the first non-blank of the 1-based line.
+func editorGotoLine(line int) {
+	if line > goedit.numOfRows {
+		line = goedit.numOfRows
+	}
+	if line < 1 {
+		line = 1
+	}
+
+	goedit.cursor.y = line - 1
+	goedit.cursor.x = 0
+	if goedit.cursor.y < goedit.numOfRows {
+		goedit.cursor.x = len(leadingWhitespace(goedit.rows[goedit.cursor.y].text()))
+	}
+}
+
+func exEdit(c *exCmd) error {
+	if c.arg == "" {
+		return exErr(32, "No file name", "")
+	}
+
+	return editorEditFile(c.arg, c.bang)
+}
+
+func exMark(c *exCmd) error {
+	if len(c.arg) != 1 || c.arg[0] < 'a' || c.arg[0] > 'z' {
+		return exErr(191, "Argument must be a letter", c.arg)
+	}
+
+	goedit.marks[rune(c.arg[0])] = cursor{x: 0, y: c.line2 - 1}
+	return nil
+}
+
+func exMap(c *exCmd) error {
+	return editorMapCommand(c.def.name, c.bang, c.arg)
+}
+
+func exUnmap(c *exCmd) error {
+	return editorUnmapCommand(c.def.name, c.bang, c.arg)
+}
+
+func exQuit(c *exCmd) error {
+	editorQuit(c.bang)
+	return nil
+}
+
+func exSet(c *exCmd) error {
+	return editorSetCommand(c.args(), c.def.name == "setlocal")
+}
+
+func exSource(c *exCmd) error {
+	if c.arg == "" {
+		return exErr(471, "Argument required", "")
+	}
+
+	return sourceFile(c.arg)
+}
+
+// editorJumpToMark moves to mark, to the first non-blank of its line when
+// linewise is set.
+func editorJumpToMark(mark rune, linewise bool) {
+	pos, ok := goedit.marks[mark]
+	if !ok {
+		editorError(exErr(20, "Mark not set", ""))
+		return
+	}
+
+	if linewise {
+		editorGotoLine(pos.y + 1)
+		return
+	}
+
+	goedit.cursor = pos
+	goedit.moveCursor(0)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// writeText writes text to filename, replacing what it held or appending to
+// it, and returns the number of bytes written.
+func writeText(filename string, text string, appendMode bool) (int, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendMode {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	file, err := os.OpenFile(filename, flags, 0644)
+	if err != nil {
+		return 0, exErr(212, "Can't open file for writing", filename)
+	}
+
+	n, err := file.WriteString(text)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return n, exErr(514, "Write error", err.Error())
+	}
+
+	return n, nil
+}
+
+// readLines reads filename the way openFile does, one row per line.
+func readLines(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, exErr(484, "Can't open file", filename)
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, exErr(484, "Can't open file", filename)
+	}
+
+	return lines, nil
+}
+
+// editorEditFile replaces the buffer with filename, refusing to throw away
+// unsaved changes unless force is set.
+func editorEditFile(filename string, force bool) error {
+	if goedit.modifiyed && !force {
+		return exErr(37, "No write since last change (add ! to override)", "")
+	}
+
+	return openFile(filename)
+}
+
+func linesText(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// exRead implements :[range]r[ead] [file] and :r !cmd, putting the file or the
+// output of cmd below the last line of the range, or above the first line for
+// line 0. Without a file the buffer's own file is read.
+func exRead(c *exCmd) error {
+	if strings.HasPrefix(c.arg, "!") {
+		return readCommand(c.line2, strings.TrimSpace(c.arg[1:]))
+	}
+
+	filename := c.arg
+	if filename == "" {
+		filename = goedit.filename
+	}
+
+	if filename == "" {
+		return exErr(32, "No file name", "")
+	}
+
+	lines, err := readLines(filename)
+	if err != nil {
+		return err
+	}
+
+	if len(lines) > 0 {
+		saveUndo()
+		replaceLines(c.line2, c.line2-1, lines)
+		editorGotoLine(c.line2 + 1)
+	}
+	editorMessage(fmt.Sprintf("\"%s\" %dL", filename, len(lines)))
+
+	return nil
+}
+
+// exWrite implements :[range]w[rite][!] [>>] [file], :w !cmd, :up[date], :wq
+// and :x[it]. :update and :xit only write a modified buffer.
+func exWrite(c *exCmd) error {
+	if c.def.name == "write" && strings.HasPrefix(c.arg, "!") {
+		cmd, err := expandShellCommand(strings.TrimSpace(c.arg[1:]))
+		if err != nil {
+			return err
+		}
+
+		editorShellOutput(cmd, linesText(rangeLines(c.line1-1, c.line2-1)))
+		return nil
+	}
+
+	if goedit.modifiyed || (c.def.name != "update" && c.def.name != "xit") {
+		if err := editorWrite(c); err != nil {
+			return err
+		}
+	}
+
+	if c.def.name == "wq" || c.def.name == "xit" {
+		editorQuit(true)
+	}
+
+	return nil
+}
+
+// editorWrite writes the range of c to the file it names, or appends it after
+// ">>". A buffer without a file takes the name of the file written. Only
+// writing the whole buffer to its own file marks it unmodified.
+func editorWrite(c *exCmd) error {
+	filename := c.arg
+	appendMode := strings.HasPrefix(filename, ">>")
+	if appendMode {
+		filename = strings.TrimSpace(filename[2:])
+	}
+
+	if filename != "" && filename != goedit.filename && !appendMode && !c.bang && fileExists(filename) {
+		return exErr(13, "File exists (add ! to override)", "")
+	}
+
+	if goedit.filename == "" && filename != "" && !appendMode {
+		goedit.filename = filename
+		selectSyntax(filename)
+		goedit.updateAllRows()
+	}
+
+	whole := c.line1 <= 1 && c.line2 >= goedit.numOfRows
+	if filename == "" || filename == goedit.filename {
+		if whole && !appendMode {
+			return goedit.save()
+		}
+
+		if !whole && !appendMode && !c.bang {
+			return exErr(140, "Use ! to write partial buffer", "")
+		}
+
+		filename = goedit.filename
+		if filename == "" {
+			return exErr(32, "No file name", "")
+		}
+	}
+
+	lines := rangeLines(c.line1-1, c.line2-1)
+	n, err := writeText(filename, linesText(lines), appendMode)
+	if err != nil {
+		return err
+	}
+
+	verb := "written to disk"
+	if appendMode {
+		verb = "appended"
+	}
+	editorMessage(fmt.Sprintf("\"%s\" %dL %d bytes %s", filename, len(lines), n, verb))
+
+	return nil
+}
+
+// exSaveas implements :sav[eas][!] file, writing the buffer to file and
+// making it the buffer's file from then on. The buffer keeps its :setlocal
+// options, and the language server is told the document was renamed.
+func exSaveas(c *exCmd) error {
+	if c.arg == "" {
+		return exErr(471, "Argument required", "")
+	}
+
+	if c.arg != goedit.filename && !c.bang && fileExists(c.arg) {
+		return exErr(13, "File exists (add ! to override)", "")
+	}
+
+	old := goedit.filename
+	local := bufferOptions()
+	rename := func(filename string) {
+		goedit.filename = filename
+		selectSyntax(filename)
+		restoreBufferOptions(local)
+		goedit.updateAllRows()
+		lspCloseDocument()
+		lspOpenDocument()
+	}
+
+	rename(c.arg)
+	if err := goedit.save(); err != nil {
+		rename(old)
+		return err
+	}
+
+	return nil
+}
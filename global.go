@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+)
+
+// exGlobal implements :[range]g/pattern/cmd and :v/pattern/cmd (or :g!). The
+// matching lines are marked first and cmd then runs with the cursor on each
+// marked line in turn, so lines deleted or moved by cmd are handled. Without
+// a cmd the matching lines are listed.
+func exGlobal(c *exCmd) error {
+	invert := c.bang || c.def.name == "vglobal"
+	if c.arg == "" || !isSubstituteDelim(c.arg[0]) {
+		return exErr(476, "Invalid command", "")
+	}
+
+	pattern, cmd := splitDelimited(c.arg[1:], c.arg[0])
+	if pattern == "" {
+		pattern = goedit.search.query
+	}
+
+	if pattern == "" {
+		return exErr(35, "No previous regular expression", "")
+	}
+
+	re, err := compilePattern(pattern)
+	if err != nil {
+		return err
+	}
+	goedit.search.query = pattern
+
+	found := 0
+	for y := c.line1 - 1; y < c.line2 && y < goedit.numOfRows; y++ {
+		goedit.rows[y].marked = re.MatchString(goedit.rows[y].text()) != invert
+		if goedit.rows[y].marked {
+			found++
+		}
+	}
+
+	if found == 0 {
+		return exErr(486, "Pattern not found", pattern)
+	}
+
+	if cmd == "" {
+		var lines []string
+		for y := range goedit.rows {
+			if goedit.rows[y].marked {
+				goedit.rows[y].marked = false
+				lines = append(lines, fmt.Sprintf("%*d %s", goedit.lineNumOffSet-goedit.signWidth-1, y+1, goedit.rows[y].text()))
+			}
+		}
+		editorShowLines(lines)
+		return nil
+	}
+
+	state := beginUndoGroup()
+	defer endUndoGroup(state)
+
+	for {
+		y := nextMarkedRow()
+		if y == -1 {
+			return nil
+		}
+
+		goedit.rows[y].marked = false
+		goedit.cursor = cursor{x: 0, y: y}
+		if err := executeCommand(cmd); err != nil {
+			if e, ok := err.(exError); ok && e.code == 486 {
+				continue
+			}
+
+			clearMarkedRows()
+			return err
+		}
+	}
+}
+
+func nextMarkedRow() int {
+	for y := range goedit.rows {
+		if goedit.rows[y].marked {
+			return y
+		}
+	}
+
+	return -1
+}
+
+func clearMarkedRows() {
+	for y := range goedit.rows {
+		goedit.rows[y].marked = false
+	}
+}
+
+// exNormal implements :[range]normal[!] {keys}, running keys as if typed in
+// normal mode, once per line of the range or once at the cursor. With ! the
+// keys are not mapped.
+func exNormal(c *exCmd) error {
+	if c.arg == "" {
+		return exErr(471, "Argument required", "")
+	}
+
+	state := beginUndoGroup()
+	defer endUndoGroup(state)
+
+	if c.addrs == 0 {
+		editorRunKeys([]rune(c.arg), c.bang)
+		return nil
+	}
+
+	for y := c.line1 - 1; y < c.line2 && y < goedit.numOfRows; y++ {
+		goedit.cursor = cursor{x: 0, y: y}
+		editorRunKeys([]rune(c.arg), c.bang)
+	}
+
+	return nil
+}
+
+// editorRunKeys feeds keys through processKeyPress as if they were typed,
+// ending in normal mode.
+func editorRunKeys(keys []rune, noremap bool) {
+	saved, executing, mode := goedit.typeahead, goedit.executingKeys, goedit.mode
+	goedit.typeahead = nil
+	for _, k := range keys {
+		goedit.typeahead = append(goedit.typeahead, typedKey{key: k, noremap: noremap})
+	}
+	goedit.executingKeys = true
+	goedit.mode = NORMAL_MODE
+
+	for len(goedit.typeahead) > 0 {
+		processKeyPress()
+	}
+
+	if goedit.mode == INSERT_MODE {
+		editorMessage("")
+	}
+	goedit.typeahead, goedit.executingKeys, goedit.mode = saved, executing, mode
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// editorGotoDefinition implements gd, jumping to the declaration of the
+// identifier under the cursor. The language server answers when it runs,
+// otherwise the package is type checked to find it.
+func editorGotoDefinition() error {
+	if goedit.lsp != nil {
+		if err := lspDefinition(); err == nil {
+			return nil
+		}
+	}
+
+	pkg, obj, err := objectAtCursor()
+	if err != nil {
+		return err
+	}
+
+	if !obj.Pos().IsValid() {
+		return fmt.Errorf("%s is predeclared", obj.Name())
+	}
+
+	return editorGotoDeclaration(positionEntry(pkg.fset.Position(obj.Pos())))
+}
+
+// editorReferences implements gr, putting the references to the identifier
+// under the cursor in the quickfix list. Without a language server the
+// package is type checked for them, which fills the list at once. The test
+// files of the package and, for an exported identifier of the module, its
+// other packages are then searched in the background, and what is found
+// there is added to the list when the search is done.
+func editorReferences() error {
+	if goedit.lsp != nil {
+		if err := lspReferences(); err == nil {
+			return nil
+		}
+	}
+
+	pkg, obj, err := objectAtCursor()
+	if err != nil {
+		return err
+	}
+
+	if obj.Pkg() == nil || !obj.Pos().IsValid() {
+		return fmt.Errorf("%s is predeclared", obj.Name())
+	}
+
+	var positions []token.Position
+	for _, idents := range []map[*ast.Ident]types.Object{pkg.info.Defs, pkg.info.Uses} {
+		for id, o := range idents {
+			if o == obj {
+				positions = append(positions, pkg.fset.Position(id.Pos()))
+			}
+		}
+	}
+
+	if len(positions) == 0 {
+		return fmt.Errorf("no references found")
+	}
+
+	title := "References to " + obj.Name()
+	if err := fillList(&goedit.quickfix, title, positionEntries(positions), false, false); err != nil {
+		return err
+	}
+
+	// A local identifier is not seen outside the file.
+	if obj.Parent() != nil && obj.Parent() != obj.Pkg().Scope() {
+		return nil
+	}
+
+	search := refSearch{
+		name:     obj.Name(),
+		path:     obj.Pkg().Path(),
+		declName: obj.Pkg().Name(),
+		decl:     pkg.fset.Position(obj.Pos()),
+		exported: obj.Exported(),
+		filename: goedit.filename,
+		pkgName:  pkg.pkg.Name(),
+		pkgPath:  pkg.pkg.Path(),
+	}
+	goedit.refSearch++
+	id := goedit.refSearch
+	editorMessage("Searching for more references ...")
+
+	go func() {
+		more := moduleReferences(search)
+		goedit.events <- func() {
+			finishReferences(id, title, positions, more)
+		}
+	}()
+
+	return nil
+}
+
+// finishReferences adds the references the background search of gr found
+// to the quickfix list, unless another list or search replaced it meanwhile.
+func finishReferences(id int, title string, positions []token.Position, more []token.Position) {
+	list := &goedit.quickfix
+	if id != goedit.refSearch || list.title != title {
+		return
+	}
+
+	if len(more) > 0 {
+		current := list.entries[list.idx]
+		setList(list, title, positionEntries(append(positions, more...)))
+		for i, e := range list.entries {
+			if e.filename == current.filename && e.line == current.line && e.col == current.col {
+				list.idx = i
+				if goedit.qfWin.list == list {
+					goedit.qfWin.sel = i
+				}
+			}
+		}
+	}
+
+	editorMessage(fmt.Sprintf("(%d references)", len(list.entries)))
+}
+
+// positionEntries makes quickfix entries of positions, sorted by file and
+// place in the file.
+func positionEntries(positions []token.Position) []qfEntry {
+	sort.Slice(positions, func(i, j int) bool {
+		a, b := positions[i], positions[j]
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+
+	var entries []qfEntry
+	for _, pos := range positions {
+		entries = append(entries, positionEntry(pos))
+	}
+
+	return entries
+}
+
+// objectAtCursor type checks the package of the buffer and returns the
+// object the identifier under the cursor declares or refers to.
+func objectAtCursor() (*checkedPackage, types.Object, error) {
+	if !strings.HasSuffix(goedit.filename, ".go") {
+		return nil, nil, fmt.Errorf("not a Go file")
+	}
+
+	pkg, err := checkPackage(goedit.filename, goedit.rowsToString())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	id := pkg.identAt(pkg.pos(bufferOffset(goedit.cursor.y, goedit.cursor.x)))
+	if id == nil {
+		return nil, nil, fmt.Errorf("no identifier under the cursor")
+	}
+
+	obj := pkg.object(id)
+	if obj == nil {
+		return nil, nil, fmt.Errorf("no declaration found for %s", id.Name)
+	}
+
+	return pkg, obj, nil
+}
+
+// positionEntry makes a quickfix entry of pos showing the line it is on.
+func positionEntry(pos token.Position) qfEntry {
+	filename := relativePath(pos.Filename)
+	return qfEntry{
+		filename: filename,
+		line:     pos.Line,
+		col:      pos.Column,
+		text:     strings.TrimSpace(fileLine(filename, pos.Line-1)),
+		valid:    true,
+	}
+}
+
+// refSearch is what gr looks for outside the buffer's package: the object
+// called name of the package at path, named declName, declared at decl. It is copied out of
+// the type checked package so the search can run on a goroutine of its own.
+type refSearch struct {
+	name     string
+	path     string
+	declName string
+	decl     token.Position
+	exported bool
+	filename string
+	pkgName  string
+	pkgPath  string
+}
+
+// moduleReferences finds the references of s the buffer's package left out:
+// those in its test files, and for an identifier exported by a package of
+// the module those in the packages of the module that declare it or import
+// its package. They are read from disk with an importer of their own, so
+// they see the packages as last written.
+func moduleReferences(s refSearch) []token.Position {
+	own, _ := filepath.Abs(filepath.Dir(s.filename))
+	dirs := []string{own}
+
+	root, module := findModule(own)
+	declFile, _ := filepath.Abs(s.decl.Filename)
+	if rel, err := filepath.Rel(root, declFile); s.exported && module != "" && err == nil && !strings.HasPrefix(rel, "..") {
+		dirs = nil
+		filepath.Walk(root, func(dir string, info os.FileInfo, err error) error {
+			if err != nil || !info.IsDir() {
+				return nil
+			}
+
+			name := info.Name()
+			if dir != root && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata" || name == "vendor" || fileExists(filepath.Join(dir, "go.mod"))) {
+				return filepath.SkipDir
+			}
+
+			dirs = append(dirs, dir)
+			return nil
+		})
+	}
+
+	fset := token.NewFileSet()
+	imp := importer.ForCompiler(fset, "source", nil)
+	declDir := filepath.Dir(declFile)
+	bufferIsTest := strings.HasSuffix(s.filename, "_test.go")
+
+	var found []token.Position
+	for _, dir := range dirs {
+		keep := []string{}
+		if dir == declDir {
+			keep = append(keep, s.declName)
+		}
+		if dir == own {
+			keep = append(keep, s.pkgName)
+		}
+
+		for name, files := range importingPackages(fset, dir, s.path, keep...) {
+			// The buffer's package was checked already, with its tests when
+			// the buffer is one of them.
+			onlyTests := false
+			if dir == own && name == s.pkgName {
+				if bufferIsTest {
+					continue
+				}
+				onlyTests = true
+			}
+
+			checkPath := name
+			switch {
+			case dir == own && name == s.pkgName:
+				checkPath = s.pkgPath
+			case dir == declDir && !strings.HasSuffix(name, "_test"):
+				checkPath = s.path
+			}
+
+			info := &types.Info{Defs: map[*ast.Ident]types.Object{}, Uses: map[*ast.Ident]types.Object{}}
+			conf := types.Config{Importer: imp, Error: func(error) {}}
+			conf.Check(checkPath, fset, files, info)
+			for _, idents := range []map[*ast.Ident]types.Object{info.Defs, info.Uses} {
+				for id, o := range idents {
+					pos := fset.Position(id.Pos())
+					if onlyTests && !strings.HasSuffix(pos.Filename, "_test.go") {
+						continue
+					}
+					if o != nil && o.Pkg() != nil && o.Pkg().Path() == s.path && o.Name() == s.name && samePosition(fset.Position(o.Pos()), s.decl) {
+						found = append(found, pos)
+					}
+				}
+			}
+		}
+	}
+
+	return found
+}
+
+// importingPackages parses the packages in dir that have a file importing
+// path, and the packages named in keep, grouped by package name.
+func importingPackages(fset *token.FileSet, dir string, path string, keep ...string) map[string][]*ast.File {
+	names, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	pkgs := map[string][]*ast.File{}
+	imports := map[string]bool{}
+	for _, name := range names {
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			continue
+		}
+
+		pkgs[f.Name.Name] = append(pkgs[f.Name.Name], f)
+		for _, spec := range f.Imports {
+			if p, _ := strconv.Unquote(spec.Path.Value); p == path {
+				imports[f.Name.Name] = true
+			}
+		}
+	}
+
+	for _, name := range keep {
+		imports[name] = true
+	}
+	for name := range pkgs {
+		if !imports[name] {
+			delete(pkgs, name)
+		}
+	}
+
+	return pkgs
+}
+
+// samePosition reports whether a and b are the same place of the same file.
+func samePosition(a token.Position, b token.Position) bool {
+	return a.Line == b.Line && a.Column == b.Column && sameFile(a.Filename, b.Filename)
+}
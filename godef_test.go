@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReferencesSearchesTestsAndModule(t *testing.T) {
+	dir, write, done := testModule(t)
+	defer done()
+
+	src := "package util\n\nfunc Double(n int) int { return 2 * n }\n"
+	write("util/util.go", src)
+	write("util/util_test.go", "package util\n\nvar _ = Double(1)\n")
+	write("util/x_test.go", "package util_test\n\nimport \"m/util\"\n\nvar _ = util.Double(2)\n")
+	write("main.go", "package main\n\nimport \"m/util\"\n\nfunc main() { println(util.Double(3)) }\n")
+
+	goedit.filename = filepath.Join(dir, "util", "util.go")
+	goedit.rows = testRows(strings.Split(strings.TrimSuffix(src, "\n"), "\n")...)
+	goedit.numOfRows = len(goedit.rows)
+	goedit.cursor = cursor{x: 5, y: 2}
+	defer func() {
+		goedit.filename, goedit.rows, goedit.numOfRows, goedit.cursor = "", nil, 0, cursor{}
+		goedit.quickfix = qfList{}
+	}()
+
+	if err := editorReferences(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(goedit.quickfix.entries); n != 1 {
+		t.Errorf("before the search: %d entries, want the declaration", n)
+	}
+
+	runMainLoop(t, "the module search", func() bool { return len(goedit.quickfix.entries) > 1 })
+
+	var got []string
+	for _, e := range goedit.quickfix.entries {
+		got = append(got, fmt.Sprintf("%s:%d:%d", filepath.ToSlash(relativePath(e.filename)), e.line, e.col))
+	}
+	want := []string{"main.go:5:28", "util/util.go:3:6", "util/util_test.go:3:9", "util/x_test.go:5:14"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("references = %v, want %v", got, want)
+	}
+}
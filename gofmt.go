@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/format"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"strings"
+	"unicode"
+)
+
+// FMT_RESYNC is how far ahead lineMapping looks for a line that gofmt kept
+// after lines it added or removed.
+const FMT_RESYNC = 8
+
+// exFmt implements :Fmt, formatting the buffer with gofmt's rules.
+func exFmt(c *exCmd) error {
+	return formatBuffer()
+}
+
+// formatBuffer runs the buffer through go/format and replaces the rows that
+// changed. A buffer that does not parse is left alone and the first syntax
+// error returned.
+func formatBuffer() error {
+	out, err := format.Source([]byte(goedit.rowsToString()))
+	if err != nil {
+		return formatError(err)
+	}
+
+	setBufferText(string(out))
+	return nil
+}
+
+// hasPackageClause reports whether src starts with a package clause. Saving
+// formats only such buffers, so that a new or empty Go file can be written
+// before it has one.
+func hasPackageClause(src string) bool {
+	_, err := parser.ParseFile(token.NewFileSet(), "", src, parser.PackageClauseOnly)
+	return err == nil
+}
+
+// setBufferText replaces the buffer with text, as a single change that can be
+// undone. The cursor and marks follow the text they were on.
+func setBufferText(text string) {
+	if text == goedit.rowsToString() {
+		return
+	}
+
+	old := rangeLines(0, goedit.numOfRows-1)
+	lines := outputLines(text)
+	mapping := lineMapping(old, lines)
+	move := func(pos cursor) cursor {
+		if len(lines) == 0 {
+			return cursor{}
+		}
+
+		if pos.y >= len(mapping) {
+			return cursor{x: 0, y: len(lines) - 1}
+		}
+
+		y := mapping[pos.y]
+		return cursor{x: mapColumn(old[pos.y], lines[y], pos.x), y: y}
+	}
+
+	saveUndo()
+	pos := move(goedit.cursor)
+	for mark, m := range goedit.marks {
+		goedit.marks[mark] = move(m)
+	}
+
+	replaceLines(0, goedit.numOfRows-1, lines)
+	goedit.cursor = pos
+	goedit.moveCursor(0)
+}
+
+// formatError turns an error from go/format into one naming the file and
+// the position of the first syntax error.
+func formatError(err error) error {
+	var list scanner.ErrorList
+	if errors.As(err, &list) && len(list) > 0 {
+		e := list[0]
+		return fmt.Errorf("%s:%d:%d: %s", goedit.filename, e.Pos.Line, e.Pos.Column, e.Msg)
+	}
+
+	return fmt.Errorf("%s: %v", goedit.filename, err)
+}
+
+// squeeze drops the blanks of line, which is all gofmt changes in most of
+// the lines it touches.
+func squeeze(line string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, line)
+}
+
+// lineMapping returns for each line of old the line of new it became. Lines
+// are compared without their blanks, and when they differ the next few lines
+// of each side are searched for one that matches again.
+func lineMapping(old []string, new []string) []int {
+	mapping := make([]int, len(old))
+	i, j := 0, 0
+	for i < len(old) && j < len(new) {
+		if squeeze(old[i]) == squeeze(new[j]) {
+			mapping[i] = j
+			i, j = i+1, j+1
+			continue
+		}
+
+		di, dj := resync(old[i:], new[j:])
+		for k := 0; k < di; k++ {
+			mapping[i+k] = j
+		}
+		i, j = i+di, j+dj
+	}
+
+	for ; i < len(old); i++ {
+		mapping[i] = len(new) - 1
+	}
+
+	return mapping
+}
+
+// resync returns how many lines to skip in old and in new to reach lines that
+// match again, skipping one line of each when none do within FMT_RESYNC.
+func resync(old []string, new []string) (int, int) {
+	for d := 1; d <= FMT_RESYNC; d++ {
+		for k := 0; k <= d; k++ {
+			if k < len(old) && d-k < len(new) && squeeze(old[k]) == squeeze(new[d-k]) {
+				return k, d - k
+			}
+		}
+	}
+
+	return 1, 1
+}
+
+// mapColumn finds in new the column of the text at x in old, counting only
+// characters that are not blanks.
+func mapColumn(old string, new string, x int) int {
+	n := 0
+	for i := 0; i < x && i < len(old); i++ {
+		if !unicode.IsSpace(rune(old[i])) {
+			n++
+		}
+	}
+
+	for i := 0; i < len(new); i++ {
+		if unicode.IsSpace(rune(new[i])) {
+			continue
+		}
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+
+	return len(new)
+}
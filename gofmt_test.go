@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormatOnSaveWithoutPackageClause(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goedit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(format bool) { goedit.formatOnSave = format }(goedit.formatOnSave)
+	defer func() { goedit.filename, goedit.rows, goedit.numOfRows = "", nil, 0 }()
+	goedit.formatOnSave = true
+	goedit.filename = filepath.Join(dir, "x.go")
+
+	for _, tt := range []struct {
+		lines []string
+		want  string
+		fails bool
+	}{
+		{nil, "", false},
+		{[]string{""}, "\n", false},
+		{[]string{"func f() {"}, "func f() {\n", false},
+		{[]string{"package x", "func  f() {}"}, "package x\n\nfunc f() {}\n", false},
+		{[]string{"package x", "func f() {"}, "", true},
+	} {
+		os.Remove(goedit.filename)
+		goedit.rows = testRows(tt.lines...)
+		goedit.numOfRows = len(goedit.rows)
+
+		err := goedit.save()
+		if (err != nil) != tt.fails {
+			t.Errorf("saving %q: err = %v, want failure %v", tt.lines, err, tt.fails)
+			continue
+		}
+		if got, _ := ioutil.ReadFile(goedit.filename); !tt.fails && string(got) != tt.want {
+			t.Errorf("saving %q wrote %q, want %q", tt.lines, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// stdlib maps the name of each standard library package to its import path,
+// filled the first time an import has to be guessed.
+var stdlib map[string]string
+
+// exGoImports implements :GoImports, adding the imports the buffer is missing
+// and removing those it does not use.
+func exGoImports(c *exCmd) error {
+	return importsBuffer()
+}
+
+// exGoImport implements :GoImport path, adding path to the imports.
+func exGoImport(c *exCmd) error {
+	path := strings.Trim(c.arg, "\" \t")
+	if path == "" {
+		return exErr(471, "Argument required", "")
+	}
+
+	src, err := addImport(goedit.rowsToString(), path)
+	if err != nil {
+		return err
+	}
+
+	return setFormattedText(src)
+}
+
+// exGoDrop implements :GoDrop path, removing path from the imports.
+func exGoDrop(c *exCmd) error {
+	path := strings.Trim(c.arg, "\" \t")
+	if path == "" {
+		return exErr(471, "Argument required", "")
+	}
+
+	src, err := dropImport(goedit.rowsToString(), path)
+	if err != nil {
+		return err
+	}
+
+	return setFormattedText(src)
+}
+
+// setFormattedText formats src and makes it the buffer.
+func setFormattedText(src string) error {
+	out, err := format.Source([]byte(src))
+	if err != nil {
+		return formatError(err)
+	}
+
+	setBufferText(string(out))
+	return nil
+}
+
+// importsBuffer fixes the imports of the buffer with the goimports program
+// when it is installed. Otherwise unused imports are removed and missing
+// standard library ones added.
+func importsBuffer() error {
+	src := goedit.rowsToString()
+	if path, err := exec.LookPath("goimports"); err == nil {
+		args := []string{}
+		if goedit.filename != "" {
+			args = append(args, "-srcdir", filepath.Dir(goedit.filename))
+		}
+
+		cmd := exec.Command(path, args...)
+		cmd.Stdin = strings.NewReader(src)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			msg := strings.TrimSpace(stderr.String())
+			if msg == "" {
+				return fmt.Errorf("goimports: %v", err)
+			}
+			first := strings.SplitN(msg, "\n", 2)[0]
+			return fmt.Errorf("%s", strings.Replace(first, "<standard input>", goedit.filename, 1))
+		}
+
+		setBufferText(string(out))
+		return nil
+	}
+
+	fixed, err := fixImports(goedit.filename, src)
+	if err != nil {
+		return err
+	}
+
+	return setFormattedText(fixed)
+}
+
+// parseImports parses src for editing its imports.
+func parseImports(src string) (*token.FileSet, *ast.File, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, goedit.filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, nil, formatError(err)
+	}
+
+	return fset, f, nil
+}
+
+// importName returns the name a package is used by in the file, read from
+// its package clause when the import does not give one. It reports false
+// when the package cannot be found, as its name then is not known.
+func importName(spec *ast.ImportSpec, dir string) (string, bool) {
+	if spec.Name != nil {
+		return spec.Name.Name, true
+	}
+
+	path, _ := strconv.Unquote(spec.Path.Value)
+	if pkg, err := build.Import(path, dir, 0); err == nil && pkg.Name != "" {
+		return pkg.Name, true
+	}
+
+	return "", false
+}
+
+// guessImportName returns the name the package at path is likely used by:
+// the last element of the path, without a major version or "go-" prefix.
+func guessImportName(path string) string {
+	parts := strings.Split(path, "/")
+	name := parts[len(parts)-1]
+	if len(parts) > 1 && len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		name = parts[len(parts)-2]
+	}
+
+	name = strings.TrimPrefix(name, "go-")
+	return strings.Replace(strings.Split(name, ".")[0], "-", "", -1)
+}
+
+// findImport returns the spec importing path and the declaration holding it.
+func findImport(f *ast.File, path string) (*ast.GenDecl, *ast.ImportSpec) {
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+
+		for _, spec := range gen.Specs {
+			imp := spec.(*ast.ImportSpec)
+			if p, _ := strconv.Unquote(imp.Path.Value); p == path {
+				return gen, imp
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// lineStart returns the offset in src of the start of the line pos is on.
+func lineStart(fset *token.FileSet, src string, pos token.Pos) int {
+	off := fset.Position(pos).Offset
+	return strings.LastIndex(src[:off], "\n") + 1
+}
+
+// lineEnd returns the offset in src just past the line pos is on.
+func lineEnd(fset *token.FileSet, src string, pos token.Pos) int {
+	off := fset.Position(pos).Offset
+	if i := strings.IndexByte(src[off:], '\n'); i >= 0 {
+		return off + i + 1
+	}
+
+	return len(src)
+}
+
+// addImport returns src importing path as well, in the first import
+// declaration or a new one after the package clause.
+func addImport(src string, path string) (string, error) {
+	fset, f, err := parseImports(src)
+	if err != nil {
+		return "", err
+	}
+
+	if _, spec := findImport(f, path); spec != nil {
+		return "", fmt.Errorf("%q is already imported", path)
+	}
+
+	line := strconv.Quote(path)
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+
+		if gen.Lparen.IsValid() {
+			at := lineStart(fset, src, gen.Rparen)
+			return src[:at] + "\t" + line + "\n" + src[at:], nil
+		}
+
+		start, end := fset.Position(gen.Pos()).Offset, fset.Position(gen.End()).Offset
+		spec := src[start+len("import") : end]
+		return src[:start] + "import (\n\t" + strings.TrimSpace(spec) + "\n\t" + line + "\n)" + src[end:], nil
+	}
+
+	at := lineEnd(fset, src, f.Name.End())
+	return src[:at] + "\nimport " + line + "\n" + src[at:], nil
+}
+
+// dropImport returns src no longer importing path, removing the whole
+// declaration when path was all it imported.
+func dropImport(src string, path string) (string, error) {
+	fset, f, err := parseImports(src)
+	if err != nil {
+		return "", err
+	}
+
+	gen, spec := findImport(f, path)
+	if spec == nil {
+		return "", fmt.Errorf("%q is not imported", path)
+	}
+
+	if len(gen.Specs) == 1 {
+		return src[:lineStart(fset, src, gen.Pos())] + src[lineEnd(fset, src, gen.End()):], nil
+	}
+
+	return src[:lineStart(fset, src, spec.Pos())] + src[lineEnd(fset, src, spec.End()):], nil
+}
+
+// fixImports returns src without the imports it does not use and importing
+// the standard library packages it refers to but does not import. Names
+// declared by the other files of the package are not taken for packages.
+// An import of a package that cannot be found is kept, since the name it is
+// used by is not known.
+func fixImports(filename string, src string) (string, error) {
+	_, f, err := parseImports(src)
+	if err != nil {
+		return "", err
+	}
+
+	declared := packageNames(filename, f.Name.Name)
+	used := map[string]bool{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok && id.Obj == nil && !declared[id.Name] {
+				used[id.Name] = true
+			}
+		}
+		return true
+	})
+
+	dir, _ := filepath.Abs(filepath.Dir(filename))
+	imported := map[string]bool{}
+	var unused []string
+	for _, spec := range f.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name, known := importName(spec, dir)
+		if !known {
+			// Do not add a standard package it may well provide.
+			imported[guessImportName(path)] = true
+			continue
+		}
+
+		imported[name] = true
+		if name != "_" && name != "." && !used[name] {
+			unused = append(unused, path)
+		}
+	}
+
+	var missing []string
+	for name := range used {
+		if path, ok := standardPackage(name); ok && !imported[name] {
+			missing = append(missing, path)
+		}
+	}
+	sort.Strings(missing)
+
+	for _, path := range unused {
+		if src, err = dropImport(src, path); err != nil {
+			return "", err
+		}
+	}
+
+	for _, path := range missing {
+		if src, err = addImport(src, path); err != nil {
+			return "", err
+		}
+	}
+
+	return src, nil
+}
+
+// packageNames returns the top level names declared by the other files of
+// package pkg in the directory of filename.
+func packageNames(filename string, pkg string) map[string]bool {
+	names := map[string]bool{}
+	if filename == "" {
+		return names
+	}
+
+	files, _ := filepath.Glob(filepath.Join(filepath.Dir(filename), "*.go"))
+	for _, file := range files {
+		if sameFile(file, filename) {
+			continue
+		}
+
+		f, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+		if err != nil || f.Name.Name != pkg {
+			continue
+		}
+
+		for name := range f.Scope.Objects {
+			names[name] = true
+		}
+	}
+
+	return names
+}
+
+// standardPackage returns the import path of the standard library package
+// called name. When several are, the shortest path wins, so rand is
+// math/rand rather than crypto/rand.
+func standardPackage(name string) (string, bool) {
+	if stdlib == nil {
+		stdlib = map[string]string{}
+		root := filepath.Join(build.Default.GOROOT, "src")
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.IsDir() {
+				return nil
+			}
+
+			rel, _ := filepath.Rel(root, path)
+			rel = filepath.ToSlash(rel)
+			base := info.Name()
+			if rel == "cmd" || base == "internal" || base == "vendor" || base == "testdata" {
+				return filepath.SkipDir
+			}
+
+			if files, _ := filepath.Glob(filepath.Join(path, "*.go")); rel == "." || len(files) == 0 {
+				return nil
+			}
+
+			if old, ok := stdlib[base]; !ok || len(rel) < len(old) || len(rel) == len(old) && rel < old {
+				stdlib[base] = rel
+			}
+			return nil
+		})
+	}
+
+	path, ok := stdlib[name]
+	return path, ok
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFixImportsKeepsUnknownNames(t *testing.T) {
+	dir, write, done := testModule(t)
+	defer done()
+
+	write("go-thing/thing.go", "package thing\n\nfunc X() {}\n")
+	src := `package main
+
+import (
+	"fmt"
+	"m/go-thing"
+	"m/missing/go-widget"
+	y "gopkg.in/yaml.v2"
+)
+
+func main() { thing.X(); wdg.Y(); _ = strings.TrimSpace("") }
+`
+
+	got, err := fixImports(filepath.Join(dir, "main.go"), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]bool{
+		`"fmt"`:                 false,
+		`"m/go-thing"`:          true,
+		`"m/missing/go-widget"`: true,
+		`"gopkg.in/yaml.v2"`:    false,
+		`"strings"`:             true,
+	} {
+		if strings.Contains(got, path) != want {
+			t.Errorf("imports %s = %v, want %v in\n%s", path, !want, want, got)
+		}
+	}
+}
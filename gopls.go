@@ -0,0 +1,581 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LSP_STOP_TIMEOUT is how long the server gets to shut down when the editor
+// quits before it is killed.
+const LSP_STOP_TIMEOUT = time.Second
+
+// lspServer is the language server running for Go buffers and the document
+// it has open, along with the text last sent to it.
+type lspServer struct {
+	client  *lspClient
+	cmd     *exec.Cmd
+	stdin   io.Closer
+	open    string
+	version int
+	text    string
+}
+
+// lspInbox holds what the server sent since the main loop last looked: the
+// latest diagnostics of each document and the last message to show.
+// Notifications arrive on a goroutine of the client, which must never wait on
+// the main loop, so they are left here and the main loop is only signalled.
+var lspInbox struct {
+	sync.Mutex
+	diagnostics map[string][]lspDiagnostic
+	msgType     int
+	message     string
+	queued      bool
+}
+
+// startLSP starts the lspprg server the first time a Go file is edited. A
+// server that is not installed is quietly done without. The server is
+// initialized in the background and used once it has answered.
+func startLSP() {
+	if goedit.lsp != nil || goedit.lspStarting || !goedit.useLSP || !strings.HasSuffix(goedit.filename, ".go") {
+		return
+	}
+
+	args := strings.Fields(goedit.lspPrg)
+	if len(args) == 0 {
+		return
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		editorError(err)
+		return
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		editorError(err)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		editorError(fmt.Errorf("cannot run %s: %v", args[0], err))
+		return
+	}
+
+	client := newLSPClient(stdout, stdin, lspNotify)
+	params := map[string]interface{}{
+		"processId": os.Getpid(),
+		"rootUri":   fileURI("."),
+		"capabilities": map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"hover":              map[string]interface{}{"contentFormat": []string{"plaintext"}},
+				"completion":         map[string]interface{}{"completionItem": map[string]interface{}{"snippetSupport": false}},
+				"publishDiagnostics": map[string]interface{}{},
+				"rename":             map[string]interface{}{},
+			},
+		},
+	}
+
+	goedit.lspStarting = true
+	go func() {
+		err := client.call("initialize", params, nil)
+		if err == nil {
+			client.notification("initialized", map[string]interface{}{})
+		} else {
+			cmd.Process.Kill()
+		}
+
+		goedit.events <- func() {
+			goedit.lspStarting = false
+			if err != nil {
+				editorError(err)
+				return
+			}
+			// lspSync opens the buffer's document on the next redraw.
+			goedit.lsp = &lspServer{client: client, cmd: cmd, stdin: stdin}
+		}
+	}()
+}
+
+// stopLSP asks the server to shut down and exit, as the protocol wants
+// before the editor quits, and kills it when it does not do so in time.
+func stopLSP() {
+	s := goedit.lsp
+	if s == nil {
+		return
+	}
+	goedit.lsp = nil
+
+	s.client.timeout = LSP_STOP_TIMEOUT
+	if s.client.call("shutdown", nil, nil) == nil {
+		s.client.notification("exit", nil)
+	}
+	s.stdin.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- s.cmd.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(LSP_STOP_TIMEOUT):
+		s.cmd.Process.Kill()
+		<-done
+	}
+}
+
+// lspNotify handles the notifications of the server. It runs on a goroutine
+// of the client, so it leaves them in lspInbox for the main loop.
+func lspNotify(method string, params json.RawMessage) {
+	switch method {
+	case "textDocument/publishDiagnostics":
+		var p struct {
+			URI         string          `json:"uri"`
+			Diagnostics []lspDiagnostic `json:"diagnostics"`
+		}
+		if json.Unmarshal(params, &p) != nil {
+			return
+		}
+
+		lspInbox.Lock()
+		if lspInbox.diagnostics == nil {
+			lspInbox.diagnostics = map[string][]lspDiagnostic{}
+		}
+		lspInbox.diagnostics[uriFile(p.URI)] = p.Diagnostics
+		lspInbox.Unlock()
+	case "window/showMessage":
+		var p struct {
+			Type    int    `json:"type"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(params, &p) != nil {
+			return
+		}
+
+		lspInbox.Lock()
+		lspInbox.msgType, lspInbox.message = p.Type, p.Message
+		lspInbox.Unlock()
+	default:
+		return
+	}
+
+	// Wake the main loop to redraw, unless it is busy or already woken; it
+	// reads the inbox before every redraw anyway.
+	lspInbox.Lock()
+	queue := !lspInbox.queued
+	lspInbox.queued = true
+	lspInbox.Unlock()
+	if queue {
+		select {
+		case goedit.events <- lspReadInbox:
+		default:
+			lspInbox.Lock()
+			lspInbox.queued = false
+			lspInbox.Unlock()
+		}
+	}
+}
+
+// lspReadInbox moves what the server sent into the editor. The main loop
+// calls it before each redraw.
+func lspReadInbox() {
+	lspInbox.Lock()
+	diags, msgType, message := lspInbox.diagnostics, lspInbox.msgType, lspInbox.message
+	lspInbox.diagnostics, lspInbox.message, lspInbox.queued = nil, "", false
+	lspInbox.Unlock()
+
+	for file, d := range diags {
+		goedit.diagnostics[file] = d
+	}
+
+	if message == "" {
+		return
+	}
+	if msgType == 1 {
+		editorError(fmt.Errorf("%s", message))
+	} else {
+		editorMessage(message)
+	}
+}
+
+// lspDocument returns the identifier of the buffer's document.
+func lspDocument() map[string]interface{} {
+	return map[string]interface{}{"uri": fileURI(goedit.filename)}
+}
+
+// lspOpenDocument tells the server about the file just opened, starting the
+// server when needed.
+func lspOpenDocument() {
+	startLSP()
+	s := goedit.lsp
+	if s == nil || goedit.filename == "" || !strings.HasSuffix(goedit.filename, ".go") {
+		return
+	}
+
+	s.open, s.version, s.text = goedit.filename, 1, goedit.rowsToString()
+	s.client.notification("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri":        fileURI(s.open),
+			"languageId": "go",
+			"version":    s.version,
+			"text":       s.text,
+		},
+	})
+}
+
+// lspCloseDocument tells the server the buffer no longer holds its document.
+func lspCloseDocument() {
+	s := goedit.lsp
+	if s == nil || s.open == "" {
+		return
+	}
+
+	s.client.notification("textDocument/didClose", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": fileURI(s.open)},
+	})
+	delete(goedit.diagnostics, s.open)
+	s.open = ""
+}
+
+// lspSync sends the whole text of the buffer to the server when it changed
+// since it was last sent. The main loop calls it before each redraw.
+func lspSync() {
+	s := goedit.lsp
+	if s == nil {
+		return
+	}
+
+	if s.open != goedit.filename {
+		lspCloseDocument()
+		lspOpenDocument()
+		return
+	}
+
+	text := goedit.rowsToString()
+	if s.open == "" || text == s.text {
+		return
+	}
+
+	s.version++
+	s.text = text
+	s.client.notification("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": fileURI(s.open), "version": s.version},
+		"contentChanges": []map[string]interface{}{{"text": text}},
+	})
+}
+
+// lspDidSave tells the server the buffer was written.
+func lspDidSave() {
+	lspSync()
+	if s := goedit.lsp; s != nil && s.open != "" {
+		s.client.notification("textDocument/didSave", map[string]interface{}{"textDocument": lspDocument()})
+	}
+}
+
+// lspRequest sends a request about the position of the cursor, syncing the
+// buffer first.
+func lspRequest(method string, extra map[string]interface{}, result interface{}) error {
+	lspSync()
+	s := goedit.lsp
+	if s == nil || s.open == "" {
+		return fmt.Errorf("no language server is running for this buffer")
+	}
+
+	line := ""
+	if goedit.cursor.y < goedit.numOfRows {
+		line = goedit.rows[goedit.cursor.y].text()
+	}
+
+	params := map[string]interface{}{
+		"textDocument": lspDocument(),
+		"position":     lspPosition{Line: goedit.cursor.y, Character: utf16Col(line, goedit.cursor.x)},
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+
+	return s.client.call(method, params, result)
+}
+
+// hoverText extracts the text of hover contents, which may be markup, a
+// string, a marked string or a list of them.
+func hoverText(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+
+	var markup struct {
+		Value string `json:"value"`
+	}
+	if json.Unmarshal(raw, &markup) == nil && markup.Value != "" {
+		return markup.Value
+	}
+
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) == nil {
+		var parts []string
+		for _, item := range list {
+			parts = append(parts, hoverText(item))
+		}
+		return strings.Join(parts, "\n")
+	}
+
+	return ""
+}
+
+// editorHover shows what the server knows about the identifier under the
+// cursor, in the message bar when it fits on a line.
+func editorHover() error {
+	var result struct {
+		Contents json.RawMessage `json:"contents"`
+	}
+	if err := lspRequest("textDocument/hover", nil, &result); err != nil {
+		return err
+	}
+
+	lines := outputLines(strings.TrimSpace(hoverText(result.Contents)))
+	switch {
+	case len(lines) == 0:
+		editorMessage("No information available")
+	case len(lines) == 1 && len(lines[0]) < goedit.width:
+		editorMessage(lines[0])
+	default:
+		editorShowLines(lines)
+	}
+
+	return nil
+}
+
+// exLspHover implements :LspHover.
+func exLspHover(c *exCmd) error {
+	return editorHover()
+}
+
+// parseLocations decodes a definition or references result, which is a
+// location, a list of them or a list of location links.
+func parseLocations(raw json.RawMessage) []lspLocation {
+	var one lspLocation
+	if json.Unmarshal(raw, &one) == nil && one.URI != "" {
+		return []lspLocation{one}
+	}
+
+	var items []struct {
+		lspLocation
+		TargetURI            string   `json:"targetUri"`
+		TargetSelectionRange lspRange `json:"targetSelectionRange"`
+	}
+	json.Unmarshal(raw, &items)
+
+	var locs []lspLocation
+	for _, item := range items {
+		if item.TargetURI != "" {
+			locs = append(locs, lspLocation{URI: item.TargetURI, Range: item.TargetSelectionRange})
+		} else if item.URI != "" {
+			locs = append(locs, item.lspLocation)
+		}
+	}
+
+	return locs
+}
+
+// fileLine returns line y of filename, from the buffer when it holds the file.
+func fileLine(filename string, y int) string {
+	if sameFile(filename, goedit.filename) {
+		if y < goedit.numOfRows {
+			return goedit.rows[y].text()
+		}
+		return ""
+	}
+
+	lines, err := readLines(filename)
+	if err != nil || y >= len(lines) {
+		return ""
+	}
+
+	return lines[y]
+}
+
+// locationEntry turns a location into a quickfix entry showing its line.
+func locationEntry(loc lspLocation) qfEntry {
+	filename := uriFile(loc.URI)
+	line := fileLine(filename, loc.Range.Start.Line)
+	return qfEntry{
+		filename: filename,
+		line:     loc.Range.Start.Line + 1,
+		col:      byteCol(line, loc.Range.Start.Character) + 1,
+		text:     strings.TrimSpace(line),
+		valid:    true,
+	}
+}
+
+// lspDefinition jumps to the declaration of the identifier under the cursor.
+func lspDefinition() error {
+	var raw json.RawMessage
+	if err := lspRequest("textDocument/definition", nil, &raw); err != nil {
+		return err
+	}
+
+	locs := parseLocations(raw)
+	if len(locs) == 0 {
+		return fmt.Errorf("no definition found")
+	}
+
+	return editorGotoDeclaration(locationEntry(locs[0]))
+}
+
+// exLspDefinition implements :LspDefinition.
+func exLspDefinition(c *exCmd) error {
+	return lspDefinition()
+}
+
+// lspReferences puts the references to the identifier under the cursor in the
+// quickfix list.
+func lspReferences() error {
+	var raw json.RawMessage
+	extra := map[string]interface{}{"context": map[string]bool{"includeDeclaration": true}}
+	if err := lspRequest("textDocument/references", extra, &raw); err != nil {
+		return err
+	}
+
+	var entries []qfEntry
+	for _, loc := range parseLocations(raw) {
+		entries = append(entries, locationEntry(loc))
+	}
+
+	if len(entries) == 0 {
+		return fmt.Errorf("no references found")
+	}
+
+	return fillList(&goedit.quickfix, ":LspReferences", entries, false, false)
+}
+
+// exLspReferences implements :LspReferences.
+func exLspReferences(c *exCmd) error {
+	return lspReferences()
+}
+
+// exLspRename implements :LspRename {name}, renaming the identifier under the
+// cursor everywhere the server finds it. Files other than the buffer's are
+// changed on disk.
+func exLspRename(c *exCmd) error {
+	if c.arg == "" {
+		return exErr(471, "Argument required", "")
+	}
+
+	var edit struct {
+		Changes         map[string][]lspTextEdit `json:"changes"`
+		DocumentChanges []struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			Edits []lspTextEdit `json:"edits"`
+		} `json:"documentChanges"`
+	}
+	if err := lspRequest("textDocument/rename", map[string]interface{}{"newName": c.arg}, &edit); err != nil {
+		return err
+	}
+
+	changes := edit.Changes
+	if changes == nil {
+		changes = map[string][]lspTextEdit{}
+	}
+	for _, dc := range edit.DocumentChanges {
+		changes[dc.TextDocument.URI] = append(changes[dc.TextDocument.URI], dc.Edits...)
+	}
+
+	for uri, edits := range changes {
+		filename := uriFile(uri)
+		if sameFile(filename, goedit.filename) {
+			setBufferText(applyTextEdits(goedit.rowsToString(), edits))
+			continue
+		}
+
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return exErr(484, "Can't open file", filename)
+		}
+		if _, err := writeText(filename, applyTextEdits(string(data), edits), false); err != nil {
+			return err
+		}
+	}
+
+	editorMessage(fmt.Sprintf("Renamed to %s in %d files", c.arg, len(changes)))
+	return nil
+}
+
+// diagnosticKind maps an LSP severity to the type of a quickfix entry.
+func diagnosticKind(severity int) byte {
+	switch severity {
+	case 1:
+		return 'E'
+	case 2:
+		return 'W'
+	case 3:
+		return 'I'
+	case 4:
+		return 'N'
+	}
+
+	return 'E'
+}
+
+// exLspDiagnostics implements :LspDiagnostics, putting the diagnostics of the
+// buffer in the location list.
+func exLspDiagnostics(c *exCmd) error {
+	var entries []qfEntry
+	for _, d := range bufferDiagnostics() {
+		line := fileLine(goedit.filename, d.Range.Start.Line)
+		entries = append(entries, qfEntry{
+			filename: goedit.filename,
+			line:     d.Range.Start.Line + 1,
+			col:      byteCol(line, d.Range.Start.Character) + 1,
+			kind:     diagnosticKind(d.Severity),
+			text:     d.Message,
+			valid:    true,
+		})
+	}
+
+	if len(entries) == 0 {
+		editorMessage("No diagnostics")
+		return nil
+	}
+
+	return fillList(&goedit.locList, ":LspDiagnostics", entries, false, false)
+}
+
+// bufferDiagnostics returns the diagnostics for the buffer's file.
+func bufferDiagnostics() []lspDiagnostic {
+	for file, diags := range goedit.diagnostics {
+		if sameFile(file, goedit.filename) {
+			return diags
+		}
+	}
+
+	return nil
+}
+
+// lspComplete asks the server for completions at the cursor.
+func lspComplete() ([]lspCompletionItem, error) {
+	var raw json.RawMessage
+	if err := lspRequest("textDocument/completion", nil, &raw); err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Items []lspCompletionItem `json:"items"`
+	}
+	if json.Unmarshal(raw, &list) == nil && list.Items != nil {
+		return list.Items, nil
+	}
+
+	var items []lspCompletionItem
+	json.Unmarshal(raw, &items)
+	return items, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLSPHelperProcess is not a test: run by the test binary as its lspprg,
+// it is a language server that answers initialize and shutdown, publishes a
+// diagnostic describing every didOpen, didChange and didSave it gets, and
+// appends the method of each message to the file in $GOEDIT_LSP_LOG.
+func TestLSPHelperProcess(t *testing.T) {
+	if os.Getenv("GOEDIT_LSP_LOG") == "" {
+		return
+	}
+
+	log, err := os.OpenFile(os.Getenv("GOEDIT_LSP_LOG"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		os.Exit(2)
+	}
+
+	conn := &lspClient{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+	for {
+		msg, err := conn.read()
+		if err != nil {
+			os.Exit(3)
+		}
+		fmt.Fprintln(log, msg.Method)
+
+		var p struct {
+			TextDocument struct {
+				URI     string `json:"uri"`
+				Version int    `json:"version"`
+				Text    string `json:"text"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		json.Unmarshal(msg.Params, &p)
+
+		text := p.TextDocument.Text
+		if len(p.ContentChanges) > 0 {
+			text = p.ContentChanges[0].Text
+		}
+
+		switch msg.Method {
+		case "initialize", "shutdown":
+			conn.write(lspMessage{ID: msg.ID, Result: json.RawMessage("{}")})
+		case "exit":
+			os.Exit(0)
+		case "textDocument/didOpen", "textDocument/didChange", "textDocument/didSave":
+			diag := lspDiagnostic{
+				Severity: 1,
+				Message:  fmt.Sprintf("%s %d %q", msg.Method, p.TextDocument.Version, strings.SplitN(text, "\n", 2)[0]),
+			}
+			params, _ := json.Marshal(map[string]interface{}{
+				"uri":         p.TextDocument.URI,
+				"diagnostics": []lspDiagnostic{diag},
+			})
+			conn.write(lspMessage{Method: "textDocument/publishDiagnostics", Params: params})
+		}
+	}
+}
+
+// runMainLoop runs the events and the per-redraw work of the main loop until
+// done reports true, failing the test after a few seconds.
+func runMainLoop(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		lspReadInbox()
+		lspSync()
+		if done() {
+			return
+		}
+
+		select {
+		case event := <-goedit.events:
+			event()
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestLSPServerProcess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goedit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logFile := filepath.Join(dir, "lsp.log")
+	defer os.Unsetenv("GOEDIT_LSP_LOG")
+	os.Setenv("GOEDIT_LSP_LOG", logFile)
+	// A race enabled server would otherwise wait a second before exiting,
+	// as long as stopLSP gives it.
+	defer os.Setenv("GORACE", os.Getenv("GORACE"))
+	os.Setenv("GORACE", "atexit_sleep_ms=0")
+
+	defer func(use bool, prg string) { goedit.useLSP, goedit.lspPrg = use, prg }(goedit.useLSP, goedit.lspPrg)
+	goedit.useLSP, goedit.lspPrg = true, os.Args[0]+" -test.run=^TestLSPHelperProcess$"
+
+	filename := filepath.Join(dir, "x.go")
+	goedit.filename = filename
+	goedit.rows = testRows("package x", "")
+	goedit.numOfRows = len(goedit.rows)
+	defer func() { goedit.filename, goedit.rows, goedit.numOfRows = "", nil, 0 }()
+
+	key := uriFile(fileURI(filename))
+	diagnostic := func() string {
+		if d := goedit.diagnostics[key]; len(d) == 1 {
+			return d[0].Message
+		}
+		return ""
+	}
+
+	lspOpenDocument()
+	if goedit.lsp != nil || !goedit.lspStarting {
+		t.Fatal("the server should be initializing in the background")
+	}
+
+	want := `textDocument/didOpen 1 "package x"`
+	runMainLoop(t, want, func() bool { return diagnostic() == want })
+
+	goedit.rows[0].setChars("package y")
+	want = `textDocument/didChange 2 "package y"`
+	runMainLoop(t, want, func() bool { return diagnostic() == want })
+
+	lspDidSave()
+	want = `textDocument/didSave 0 ""`
+	runMainLoop(t, want, func() bool { return diagnostic() == want })
+
+	renamed := filepath.Join(dir, "y.go")
+	if err := exSaveas(&exCmd{arg: renamed}); err != nil {
+		t.Fatal(err)
+	}
+	key = uriFile(fileURI(renamed))
+	want = `textDocument/didSave 0 ""`
+	runMainLoop(t, "the renamed document", func() bool { return diagnostic() == want })
+
+	cmd := goedit.lsp.cmd
+	stopLSP()
+	if cmd.ProcessState == nil || !cmd.ProcessState.Success() {
+		t.Errorf("server process state = %v, want it to have exited cleanly", cmd.ProcessState)
+	}
+
+	log, _ := ioutil.ReadFile(logFile)
+	methods := strings.Fields(string(log))
+	wantMethods := []string{"initialize", "initialized", "textDocument/didOpen", "textDocument/didChange", "textDocument/didSave", "textDocument/didClose", "textDocument/didOpen", "textDocument/didSave", "shutdown", "exit"}
+	if strings.Join(methods, " ") != strings.Join(wantMethods, " ") {
+		t.Errorf("server got %v, want %v", methods, wantMethods)
+	}
+}
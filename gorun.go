@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// OUTPUT_HEIGHT is how many lines the output pane shows.
+const OUTPUT_HEIGHT = 10
+
+// outputPane is the scratch pane below the text that :GoRun and :GoTest
+// stream the output of the go command into.
+type outputPane struct {
+	open   bool
+	title  string
+	lines  []string
+	height int
+	status string
+}
+
+// openOutputPane shows an empty output pane titled title, taking its lines
+// from the text area.
+func openOutputPane(title string) {
+	if !goedit.outWin.open {
+		height := OUTPUT_HEIGHT
+		if max := goedit.height - 2; height > max {
+			height = max
+		}
+		if height < 1 {
+			return
+		}
+
+		goedit.outWin.height = height
+		goedit.height -= height + 1
+	}
+
+	goedit.outWin.open = true
+	goedit.outWin.title = title
+	goedit.outWin.lines = nil
+	goedit.outWin.status = "running"
+}
+
+func closeOutputPane() {
+	if goedit.outWin.open {
+		goedit.height += goedit.outWin.height + 1
+	}
+	goedit.outWin = outputPane{}
+}
+
+// exPclose implements :pc[lose], closing the output pane.
+func exPclose(c *exCmd) error {
+	closeOutputPane()
+	return nil
+}
+
+// drawOutputPane draws the last lines of the output pane and its status line.
+func drawOutputPane() {
+	w := &goedit.outWin
+	first := len(w.lines) - w.height
+	if first < 0 {
+		first = 0
+	}
+
+	for i := 0; i < w.height; i++ {
+		if first+i < len(w.lines) {
+			line := strings.Replace(w.lines[first+i], "\t", "    ", -1)
+			if len(line) > goedit.width {
+				line = line[:goedit.width]
+			}
+			goedit.editorUI.WriteString(line)
+		} else {
+			goedit.editorUI.WriteString(fmt.Sprintf("\x1b[%dm~\x1b[39m", goedit.colors.nonText))
+		}
+
+		goedit.editorUI.WriteString("\x1b[K\r\n")
+	}
+
+	status := fmt.Sprintf("[Go Output] %s (%s)", w.title, w.status)
+	if len(status) > goedit.width {
+		status = status[:goedit.width]
+	}
+	goedit.editorUI.WriteString("\x1b[7m")
+	goedit.editorUI.WriteString(status)
+	goedit.editorUI.WriteString(strings.Repeat(" ", goedit.width-len(status)))
+	goedit.editorUI.WriteString("\x1b[m\r\n")
+}
+
+// exGoRun implements :GoRun [args], running the main package of the current
+// file with args.
+func exGoRun(c *exCmd) error {
+	if goedit.filename == "" {
+		return exErr(32, "No file name", "")
+	}
+
+	pkg := goPackageDir(goedit.filename)
+	args := append([]string{"run", pkg}, c.args()...)
+	return startGoJob(":GoRun "+c.arg, args, filepath.Dir(goedit.filename))
+}
+
+// exGoTest implements :GoTest [args], running the tests of the package of
+// the current file.
+func exGoTest(c *exCmd) error {
+	if goedit.filename == "" {
+		return exErr(32, "No file name", "")
+	}
+
+	pkg := goPackageDir(goedit.filename)
+	args := append([]string{"test", pkg}, c.args()...)
+	return startGoJob(":GoTest "+c.arg, args, filepath.Dir(goedit.filename))
+}
+
+// exGoTestFunc implements :GoTestFunc [args], running only the test,
+// benchmark, example or fuzz test the cursor is in.
+func exGoTestFunc(c *exCmd) error {
+	if goedit.filename == "" {
+		return exErr(32, "No file name", "")
+	}
+
+	name, err := testFuncAt(goedit.filename, goedit.rowsToString(), goedit.cursor.y+1)
+	if err != nil {
+		return err
+	}
+
+	run := []string{"-run", "^" + name + "$"}
+	if strings.HasPrefix(name, "Benchmark") {
+		run = []string{"-run", "^$", "-bench", "^" + name + "$"}
+	}
+
+	pkg := goPackageDir(goedit.filename)
+	args := append(append([]string{"test", pkg}, run...), c.args()...)
+	return startGoJob(":GoTestFunc "+name, args, filepath.Dir(goedit.filename))
+}
+
+// testFuncAt returns the name of the test function of src that line falls in.
+func testFuncAt(filename string, src string, line int) (string, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, 0)
+	if f == nil {
+		return "", err
+	}
+
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil {
+			continue
+		}
+
+		start, end := fset.Position(fn.Pos()).Line, fset.Position(fn.End()).Line
+		if line < start || line > end {
+			continue
+		}
+
+		for _, prefix := range []string{"Test", "Benchmark", "Example", "Fuzz"} {
+			if strings.HasPrefix(fn.Name.Name, prefix) {
+				return fn.Name.Name, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no test function under the cursor")
+}
+
+// jobOutput holds the lines a go command printed since the main loop last
+// took them, so a chatty command costs one event and redraw per batch of
+// lines rather than per line.
+type jobOutput struct {
+	sync.Mutex
+	lines  []string
+	queued bool
+}
+
+// add appends line and reports whether an event has to be posted for it.
+func (o *jobOutput) add(line string) bool {
+	o.Lock()
+	defer o.Unlock()
+	o.lines = append(o.lines, line)
+	post := !o.queued
+	o.queued = true
+	return post
+}
+
+// take returns the lines added since the last call.
+func (o *jobOutput) take() []string {
+	o.Lock()
+	defer o.Unlock()
+	lines := o.lines
+	o.lines, o.queued = nil, false
+	return lines
+}
+
+// startGoJob writes the buffer when it is modified and runs the go command
+// with args in the background, streaming what it prints into the output
+// pane. dir is the directory of the package, which go test names its files
+// relative to. The command gets a process group of its own, for :GoStop to
+// kill along with the program go run starts.
+func startGoJob(title string, args []string, dir string) error {
+	if goedit.making {
+		return exErr(948, "Job still running", "")
+	}
+
+	if goedit.modifiyed {
+		if err := goedit.save(); err != nil {
+			return err
+		}
+	}
+
+	cmd := exec.Command("go", args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stderr = cmd.Stdout
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("cannot run go: %v", err)
+	}
+
+	goedit.making = true
+	goedit.job = cmd
+	openOutputPane(title)
+
+	output := &jobOutput{}
+	show := func() {
+		if goedit.job == cmd {
+			goedit.outWin.lines = append(goedit.outWin.lines, output.take()...)
+		}
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			if output.add(scanner.Text()) {
+				goedit.events <- show
+			}
+		}
+
+		err := cmd.Wait()
+		goedit.events <- func() {
+			show()
+			if goedit.job == cmd {
+				finishGoJob(title, err, dir)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// exGoStop implements :GoStop, killing the go command :GoRun, :GoTest or
+// :GoTestFunc is running and the processes it started. Ctrl-C in normal mode
+// does the same.
+func exGoStop(c *exCmd) error {
+	return stopGoJob()
+}
+
+func stopGoJob() error {
+	if goedit.job == nil {
+		return fmt.Errorf("no go command running")
+	}
+
+	if err := syscall.Kill(-goedit.job.Process.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return err
+	}
+
+	goedit.job = nil
+	goedit.making = false
+	goedit.outWin.status = "stopped"
+	return nil
+}
+
+// finishGoJob loads the file positions in the output of a go command into
+// the quickfix list, on the main goroutine.
+func finishGoJob(title string, err error, dir string) {
+	goedit.making = false
+	goedit.job = nil
+	goedit.outWin.status = "done"
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		goedit.outWin.status = fmt.Sprintf("exit %d", exit.ExitCode())
+	} else if err != nil {
+		goedit.outWin.status = err.Error()
+	}
+
+	entries, perr := errorEntries(goedit.outWin.lines)
+	if perr != nil {
+		editorError(perr)
+		return
+	}
+
+	var valid []qfEntry
+	for _, e := range entries {
+		if !e.valid {
+			continue
+		}
+
+		if !filepath.IsAbs(e.filename) && !fileExists(e.filename) {
+			if joined := filepath.Join(dir, e.filename); fileExists(joined) {
+				e.filename = joined
+			}
+		}
+		valid = append(valid, e)
+	}
+
+	if len(valid) > 0 {
+		setList(&goedit.quickfix, title, valid)
+		goedit.quickfix.build = true
+		editorError(fmt.Errorf("%s: %d locations in the quickfix list", title, len(valid)))
+		return
+	}
+
+	editorMessage(fmt.Sprintf("%s: %s", title, goedit.outWin.status))
+}
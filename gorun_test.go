@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGoStopKillsProgram(t *testing.T) {
+	dir, write, done := testModule(t)
+	defer done()
+
+	write("main.go", "package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n\t\"time\"\n)\n\nfunc main() {\n\tfmt.Println(os.Getpid())\n\ttime.Sleep(time.Minute)\n}\n")
+	defer closeOutputPane()
+
+	if err := startGoJob(":GoRun", []string{"run", "."}, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := startGoJob(":GoRun", []string{"run", "."}, dir); err == nil || !strings.Contains(err.Error(), "E948") {
+		t.Errorf("second job: err = %v, want E948", err)
+	}
+
+	runMainLoop(t, "the program to start", func() bool { return len(goedit.outWin.lines) > 0 })
+	pid, err := strconv.Atoi(goedit.outWin.lines[0])
+	if err != nil {
+		t.Fatalf("output %q, want the pid", goedit.outWin.lines)
+	}
+
+	if err := exGoStop(nil); err != nil {
+		t.Fatal(err)
+	}
+	if goedit.making || goedit.job != nil || goedit.outWin.status != "stopped" {
+		t.Errorf("after :GoStop making %v job %v status %q", goedit.making, goedit.job, goedit.outWin.status)
+	}
+	if err := exGoStop(nil); err == nil {
+		t.Error("second :GoStop succeeded")
+	}
+
+	// The program is gone once it has no /proc entry or is a zombie.
+	gone := func() bool {
+		stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		return err != nil || strings.Contains(string(stat), ") Z ")
+	}
+	runMainLoop(t, "the program to be killed", gone)
+
+	// The end of the killed command must not finish a later job.
+	time.Sleep(50 * time.Millisecond)
+	runMainLoop(t, "the events", func() bool { return len(goedit.events) == 0 })
+	if goedit.outWin.status != "stopped" {
+		t.Errorf("status %q after the killed command ended, want stopped", goedit.outWin.status)
+	}
+}
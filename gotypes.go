@@ -0,0 +1,282 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strings"
+)
+
+// checkedPackage is the package of the buffer's file, type checked from
+// source with the buffer as that file.
+type checkedPackage struct {
+	fset  *token.FileSet
+	file  *ast.File
+	files []*ast.File
+	pkg   *types.Package
+	info  *types.Info
+}
+
+// checkPackage parses the buffer along with the other files of its package
+// and type checks them. Errors are ignored so that a package being edited
+// can still be looked into; it only fails when the buffer has no package
+// clause at all. Each check has a file set and importer of its own, so the
+// imported packages are read as they are now on disk and nothing is kept
+// once the result is dropped.
+func checkPackage(filename string, src string) (*checkedPackage, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments|parser.AllErrors)
+	if file == nil || file.Name == nil || file.Name.Name == "" || file.Name.Name == "_" {
+		return nil, formatError(err)
+	}
+
+	files := []*ast.File{file}
+	names, _ := filepath.Glob(filepath.Join(filepath.Dir(filename), "*.go"))
+	for _, name := range names {
+		if sameFile(name, filename) || strings.HasSuffix(name, "_test.go") && !strings.HasSuffix(filename, "_test.go") {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, name, nil, parser.ParseComments)
+		if err == nil && f.Name.Name == file.Name.Name {
+			files = append(files, f)
+		}
+	}
+
+	info := &types.Info{
+		Types:  map[ast.Expr]types.TypeAndValue{},
+		Defs:   map[*ast.Ident]types.Object{},
+		Uses:   map[*ast.Ident]types.Object{},
+		Scopes: map[ast.Node]*types.Scope{},
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil), Error: func(error) {}}
+	pkg, _ := conf.Check(importPath(filepath.Dir(filename), file.Name.Name), fset, files, info)
+
+	return &checkedPackage{fset: fset, file: file, files: files, pkg: pkg, info: info}, nil
+}
+
+// pos returns the position of byte offset off of the buffer's file.
+func (c *checkedPackage) pos(off int) token.Pos {
+	tf := c.fset.File(c.file.Pos())
+	if off > tf.Size() {
+		off = tf.Size()
+	}
+
+	return tf.Pos(off)
+}
+
+// identAt returns the identifier at pos in the buffer's file.
+func (c *checkedPackage) identAt(pos token.Pos) *ast.Ident {
+	var found *ast.Ident
+	ast.Inspect(c.file, func(n ast.Node) bool {
+		if n == nil || found != nil || pos < n.Pos() || pos > n.End() {
+			return false
+		}
+		if id, ok := n.(*ast.Ident); ok && pos < id.End() {
+			found = id
+		}
+		return true
+	})
+
+	return found
+}
+
+// object returns the object id declares or refers to.
+func (c *checkedPackage) object(id *ast.Ident) types.Object {
+	if obj := c.info.Defs[id]; obj != nil {
+		return obj
+	}
+
+	return c.info.Uses[id]
+}
+
+// bufferOffset returns the byte offset of column x of row y in the text
+// rowsToString returns.
+func bufferOffset(y int, x int) int {
+	off := x
+	for i := 0; i < y && i < goedit.numOfRows; i++ {
+		off += goedit.rows[i].size + 1
+	}
+
+	return off
+}
+
+// findModule returns the directory of the go.mod above dir and the module
+// path it declares, or empty strings outside a module.
+func findModule(dir string) (string, string) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", ""
+	}
+
+	for {
+		if lines, err := readLines(filepath.Join(abs, "go.mod")); err == nil {
+			for _, line := range lines {
+				if f := strings.Fields(line); len(f) == 2 && f[0] == "module" {
+					return abs, strings.Trim(f[1], `"`)
+				}
+			}
+			return abs, ""
+		}
+
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return "", ""
+		}
+		abs = parent
+	}
+}
+
+// importPath returns the path the package in dir is imported by, or name
+// when dir is not in a module.
+func importPath(dir string, name string) string {
+	root, module := findModule(dir)
+	if module == "" {
+		return name
+	}
+
+	abs, _ := filepath.Abs(dir)
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || rel == "." {
+		return module
+	}
+
+	return module + "/" + filepath.ToSlash(rel)
+}
+
+// scopeNames returns the objects visible at pos, innermost first and without
+// those they shadow.
+func (c *checkedPackage) scopeNames(pos token.Pos) []types.Object {
+	var objs []types.Object
+	seen := map[string]bool{}
+	for s := c.pkg.Scope().Innermost(pos); s != nil; s = s.Parent() {
+		local := s != c.pkg.Scope() && s != types.Universe && s.Parent() != c.pkg.Scope()
+		for _, name := range s.Names() {
+			obj := s.Lookup(name)
+			if seen[name] || name == "_" || local && obj.Pos() > pos {
+				continue
+			}
+			seen[name] = true
+			objs = append(objs, obj)
+		}
+	}
+
+	return objs
+}
+
+// members returns the fields and methods of a value of type t that code in
+// package pkg can use, including those promoted from embedded fields.
+func members(t types.Type, pkg *types.Package) []types.Object {
+	var objs []types.Object
+	seen := map[string]bool{}
+	add := func(obj types.Object) {
+		if !seen[obj.Name()] && (obj.Exported() || obj.Pkg() == pkg) {
+			seen[obj.Name()] = true
+			objs = append(objs, obj)
+		}
+	}
+
+	mset := types.NewMethodSet(t)
+	if _, ok := t.Underlying().(*types.Interface); !ok {
+		if _, ok := t.(*types.Pointer); !ok {
+			mset = types.NewMethodSet(types.NewPointer(t))
+		}
+	}
+
+	var fields func(t types.Type, depth int)
+	fields = func(t types.Type, depth int) {
+		if p, ok := t.Underlying().(*types.Pointer); ok {
+			t = p.Elem()
+		}
+		st, ok := t.Underlying().(*types.Struct)
+		if !ok || depth > 3 {
+			return
+		}
+
+		for i := 0; i < st.NumFields(); i++ {
+			add(st.Field(i))
+		}
+		for i := 0; i < st.NumFields(); i++ {
+			if st.Field(i).Embedded() {
+				fields(st.Field(i).Type(), depth+1)
+			}
+		}
+	}
+
+	fields(t, 0)
+	for i := 0; i < mset.Len(); i++ {
+		add(mset.At(i).Obj())
+	}
+
+	return objs
+}
+
+// selectorMembers returns what may follow the dot before byte offset dot of
+// the buffer's file: the exported names of a package, or the fields and
+// methods of a value. It reports false when the operand has no known type.
+func (c *checkedPackage) selectorMembers(dot int) ([]types.Object, bool) {
+	at := c.pos(dot)
+	var operand ast.Expr
+	ast.Inspect(c.file, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok && sel.X.End() == at {
+			operand = sel.X
+		}
+		return operand == nil
+	})
+
+	if id, ok := operand.(*ast.Ident); ok {
+		if pkgName, ok := c.info.Uses[id].(*types.PkgName); ok {
+			scope := pkgName.Imported().Scope()
+			var objs []types.Object
+			for _, name := range scope.Names() {
+				if obj := scope.Lookup(name); obj.Exported() {
+					objs = append(objs, obj)
+				}
+			}
+			return objs, true
+		}
+	}
+
+	if operand != nil {
+		if tv, ok := c.info.Types[operand]; ok && tv.Type != nil {
+			return members(tv.Type, c.pkg), true
+		}
+	}
+
+	return nil, false
+}
+
+// objectKind returns a letter for the kind of obj, as completion menus show.
+func objectKind(obj types.Object) string {
+	switch obj := obj.(type) {
+	case *types.Func:
+		return "f"
+	case *types.Var:
+		if obj.IsField() {
+			return "m"
+		}
+		return "v"
+	case *types.Const:
+		return "c"
+	case *types.TypeName:
+		return "t"
+	case *types.PkgName:
+		return "p"
+	}
+
+	return ""
+}
+
+// objectString describes obj with the names of other packages qualified by
+// their package name.
+func objectString(obj types.Object, pkg *types.Package) string {
+	return types.ObjectString(obj, func(p *types.Package) string {
+		if p == pkg {
+			return ""
+		}
+		return p.Name()
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testModule makes module m in a temporary directory and changes to it, as
+// go/build finds the packages of a module by running the go command in the
+// working directory. It returns the directory, a function writing a file in
+// it and one undoing it all.
+func testModule(t *testing.T) (string, func(name string, text string), func()) {
+	dir, err := ioutil.TempDir("", "goedit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wd, _ := os.Getwd()
+	module := os.Getenv("GO111MODULE")
+	os.Setenv("GO111MODULE", "on")
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(name string, text string) {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module m\n")
+
+	return dir, write, func() {
+		os.Chdir(wd)
+		os.Setenv("GO111MODULE", module)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCheckPackageSeesChangedImports(t *testing.T) {
+	dir, write, done := testModule(t)
+	defer done()
+
+	write("util/util.go", "package util\n\nfunc Old() {}\n")
+	src := "package main\n\nimport \"m/util\"\n\nfunc main() { util.Old() }\n"
+
+	lookup := func() (bool, bool) {
+		pkg, err := checkPackage(filepath.Join(dir, "main.go"), src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		imp := pkg.pkg.Imports()
+		if len(imp) != 1 {
+			t.Fatalf("imports = %v, want m/util", imp)
+		}
+		return imp[0].Scope().Lookup("Old") != nil, imp[0].Scope().Lookup("New") != nil
+	}
+
+	if old, new := lookup(); !old || new {
+		t.Fatalf("first check: Old %v New %v, want only Old", old, new)
+	}
+
+	write("util/util.go", "package util\n\nfunc New() {}\n")
+	if old, new := lookup(); old || !new {
+		t.Errorf("after util.go changed: Old %v New %v, want only New", old, new)
+	}
+}
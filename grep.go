@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// exVimgrep implements :vim[grep][!] /pattern/[g][j] file... The files, which
+// may be globs like **/*.go, are searched in Go and every matching line goes
+// into the quickfix list, or the location list for :lv[imgrep]. With g each
+// match on a line is listed, and with j the cursor does not jump to the first
+// one.
+func exVimgrep(c *exCmd) error {
+	arg := strings.TrimSpace(c.arg)
+	if arg == "" {
+		return exErr(683, "File name missing or invalid pattern", "")
+	}
+
+	var pattern, rest string
+	if isSubstituteDelim(arg[0]) {
+		pattern, rest = splitDelimited(arg[1:], arg[0])
+	} else {
+		fields := strings.SplitN(arg, " ", 2)
+		pattern = fields[0]
+		if len(fields) > 1 {
+			rest = fields[1]
+		}
+	}
+
+	all, jump := false, true
+	for rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		switch rest[0] {
+		case 'g':
+			all = true
+		case 'j':
+			jump = false
+		default:
+			return exErr(683, "File name missing or invalid pattern", "")
+		}
+		rest = rest[1:]
+	}
+
+	if pattern == "" {
+		pattern = goedit.search.query
+	}
+	if pattern == "" {
+		return exErr(35, "No previous regular expression", "")
+	}
+
+	re, err := compilePattern(pattern)
+	if err != nil {
+		return err
+	}
+
+	files, err := expandFiles(strings.Fields(rest))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return exErr(683, "File name missing or invalid pattern", "")
+	}
+
+	var entries []qfEntry
+	for _, file := range files {
+		entries = append(entries, grepFile(file, re, all)...)
+	}
+
+	if len(entries) == 0 {
+		return exErr(480, "No match", pattern)
+	}
+
+	goedit.search.query = pattern
+	return fillList(listFor(c), ":"+c.def.name+" "+c.arg, entries, jump, c.bang)
+}
+
+// grepFile returns the lines of filename matching re. Files that look
+// binary, holding a NUL byte, are skipped.
+func grepFile(filename string, re *regexp.Regexp, all bool) []qfEntry {
+	data, err := os.ReadFile(filename)
+	if err != nil || bytes.IndexByte(data, 0) >= 0 {
+		return nil
+	}
+
+	var entries []qfEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		for _, m := range re.FindAllStringIndex(text, -1) {
+			entries = append(entries, qfEntry{filename: filename, line: line, col: m[0] + 1, text: strings.TrimSpace(text), valid: true})
+			if !all {
+				break
+			}
+		}
+	}
+
+	return entries
+}
+
+// expandFiles turns the file arguments of :vimgrep into file names. % is the
+// current file and arguments holding *, ? or [ are globs matched against the
+// files below the working directory that git would not ignore.
+func expandFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		switch {
+		case arg == "%":
+			if goedit.filename == "" {
+				return nil, exErr(499, "Empty file name for '%'", "")
+			}
+			files = append(files, goedit.filename)
+		case strings.ContainsAny(arg, "*?["):
+			matches, err := globFiles(arg)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, matches...)
+		default:
+			files = append(files, arg)
+		}
+	}
+
+	return files, nil
+}
+
+// globRegexp translates a glob into a regexp over slash separated paths. *
+// and ? do not match a slash, while **/ matches any number of directories.
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	buf := strings.Builder{}
+	buf.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch ch := glob[i]; ch {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**/") {
+				buf.WriteString("(.*/)?")
+				i += 2
+			} else if strings.HasPrefix(glob[i:], "**") {
+				buf.WriteString(".*")
+				i++
+			} else {
+				buf.WriteString("[^/]*")
+			}
+		case '?':
+			buf.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				buf.WriteString(`\[`)
+				break
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			buf.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	buf.WriteString("$")
+
+	re, err := regexp.Compile(buf.String())
+	if err != nil {
+		return nil, exErr(683, "File name missing or invalid pattern", glob)
+	}
+
+	return re, nil
+}
+
+// ignoreRule is one line of a .gitignore file in dir.
+type ignoreRule struct {
+	dir      string
+	re       *regexp.Regexp
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// matches reports whether the rule applies to path, which is slash separated
+// and relative to the directory the walk started from.
+func (r ignoreRule) matches(path string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+
+	rel := path
+	if r.dir != "." {
+		if !strings.HasPrefix(path, r.dir+"/") {
+			return false
+		}
+		rel = path[len(r.dir)+1:]
+	}
+
+	if !r.anchored {
+		rel = rel[strings.LastIndex(rel, "/")+1:]
+	}
+
+	return r.re.MatchString(rel)
+}
+
+// readIgnoreFile parses the .gitignore in dir, if any.
+func readIgnoreFile(dir string) []ignoreRule {
+	file, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		r := ignoreRule{dir: filepath.ToSlash(dir)}
+		if line[0] == '!' {
+			r.negate = true
+			line = line[1:]
+		} else if line[0] == '\\' {
+			line = line[1:]
+		}
+
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+
+		if strings.Contains(line, "/") {
+			r.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+
+		if r.re, err = globRegexp(line); err == nil && line != "" {
+			rules = append(rules, r)
+		}
+	}
+
+	return rules
+}
+
+// ignored reports whether path is ignored; as in git the last rule matching
+// it decides.
+func ignored(rules []ignoreRule, path string, isDir bool) bool {
+	ignore := false
+	for _, r := range rules {
+		if r.matches(path, isDir) {
+			ignore = !r.negate
+		}
+	}
+
+	return ignore
+}
+
+// globFiles returns the files matching glob, walking down from the part of it
+// without wildcards. The .git directory and whatever the .gitignore files
+// exclude are left out.
+func globFiles(glob string) ([]string, error) {
+	glob = filepath.ToSlash(filepath.Clean(glob))
+	re, err := globRegexp(glob)
+	if err != nil {
+		return nil, err
+	}
+
+	root := "."
+	if i := strings.IndexAny(glob, "*?["); i > 0 {
+		if j := strings.LastIndex(glob[:i], "/"); j >= 0 {
+			root = glob[:j]
+			if root == "" {
+				root = "/"
+			}
+		}
+	}
+
+	rules := readIgnoreFile(".")
+	if !filepath.IsAbs(root) && root != "." {
+		parts := strings.Split(root, "/")
+		for i := 1; i < len(parts); i++ {
+			rules = append(rules, readIgnoreFile(strings.Join(parts[:i], "/"))...)
+		}
+	}
+
+	var files []string
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		slashed := filepath.ToSlash(path)
+		if info.IsDir() {
+			if path != root && (info.Name() == ".git" || ignored(rules, slashed, true)) {
+				return filepath.SkipDir
+			}
+			if path != "." {
+				rules = append(rules, readIgnoreFile(path)...)
+			}
+			return nil
+		}
+
+		if !ignored(rules, slashed, false) && re.MatchString(slashed) {
+			files = append(files, path)
+		}
+		return nil
+	})
+
+	return files, err
+}
+
+// exGrep implements :gr[ep][!] args and :lgr[ep][!] args, running the
+// grepprg option with args in place of $* and reading its output into the
+// quickfix or location list with the grepformat option. With ! the cursor
+// does not jump to the first match.
+func exGrep(c *exCmd) error {
+	args := strings.TrimSpace(c.arg)
+	if args == "" {
+		return exErr(471, "Argument required", "")
+	}
+
+	cmd := goedit.grepPrg
+	if strings.Contains(cmd, "$*") {
+		cmd = strings.Replace(cmd, "$*", args, -1)
+	} else {
+		cmd += " " + args
+	}
+
+	cmd, err := expandFileName(cmd)
+	if err != nil {
+		return err
+	}
+
+	out, err := runFilter(cmd, "")
+	if err != nil && !isExitError(err) {
+		return shellError(err)
+	}
+
+	formats, err := parseErrorFormat(goedit.grepFormat)
+	if err != nil {
+		return err
+	}
+
+	entries := parseErrorLines(formats, outputLines(out))
+	if len(entries) == 0 {
+		return exErr(480, "No match", args)
+	}
+
+	return fillList(listFor(c), ":"+c.def.name+" "+c.arg, entries, !c.bang, false)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"strings"
+)
+
+// indentOptions controls how tabs are displayed and how new lines are
+// indented. They are buffer local options, overridden per filetype by the
+// "indent" section of the syntax file.
+type indentOptions struct {
+	AutoIndent  bool `json:"autoindent"`
+	SmartIndent bool `json:"smartindent"`
+	ExpandTab   bool `json:"expandtab"`
+	TabStop     int  `json:"tabstop"`
+	ShiftWidth  int  `json:"shiftwidth"`
+	SoftTabStop int  `json:"softtabstop"`
+}
+
+// shiftWidth returns the width of one indent level; zero means use tabstop.
+func (o indentOptions) shiftWidth() int {
+	if o.ShiftWidth == 0 {
+		return o.TabStop
+	}
+
+	return o.ShiftWidth
+}
+
+// unit returns the text inserted for one level of indentation.
+func (o indentOptions) unit() string {
+	if o.ExpandTab {
+		return strings.Repeat(" ", o.shiftWidth())
+	}
+
+	return "\t"
+}
+
+// dedent removes one level of indentation from the end of ws.
+func (o indentOptions) dedent(ws string) string {
+	if strings.HasSuffix(ws, "\t") {
+		return ws[:len(ws)-1]
+	}
+
+	trimmed := strings.TrimRight(ws, " ")
+	if len(ws)-len(trimmed) > o.shiftWidth() {
+		return ws[:len(ws)-o.shiftWidth()]
+	}
+
+	return trimmed
+}
+
+// indentWidth returns the number of screen columns ws takes up.
+func (o indentOptions) indentWidth(ws string) int {
+	col := 0
+	for i := 0; i < len(ws); i++ {
+		if ws[i] == '\t' {
+			col += o.TabStop - col%o.TabStop
+		} else {
+			col++
+		}
+	}
+
+	return col
+}
+
+// makeIndent returns the whitespace reaching column cols, using as many tabs
+// as fit unless expandtab is set.
+func (o indentOptions) makeIndent(cols int) string {
+	if o.ExpandTab {
+		return strings.Repeat(" ", cols)
+	}
+
+	return strings.Repeat("\t", cols/o.TabStop) + strings.Repeat(" ", cols%o.TabStop)
+}
+
+func leadingWhitespace(s string) string {
+	return s[:len(s)-len(strings.TrimLeft(s, " \t"))]
+}
+
+// opensBlock reports whether the line should indent the line after it: it
+// ends with '{' or '(' or is a case/default label of a switch.
+func opensBlock(line string) bool {
+	if i := strings.Index(line, "//"); i != -1 {
+		line = line[:i]
+	}
+
+	line = strings.TrimSpace(line)
+	if strings.HasSuffix(line, "{") || strings.HasSuffix(line, "(") {
+		return true
+	}
+
+	return strings.HasSuffix(line, ":") && (strings.HasPrefix(line, "case ") || line == "default:")
+}
+
+// editorReindentLine fixes the indentation of the cursor row after c was typed
+// when c completes a closing brace or a case/default label.
+func editorReindentLine(c rune) {
+	if !goedit.indent.SmartIndent || goedit.cursor.y >= goedit.numOfRows {
+		return
+	}
+
+	row := &goedit.rows[goedit.cursor.y]
+	text := row.text()
+	ws := leadingWhitespace(text)
+	rest := text[len(ws):]
+
+	switch {
+	case (c == '}' || c == ')') && rest == string(c):
+	case c == ' ' && rest == "case ":
+	case c == ':' && rest == "default:":
+	default:
+		return
+	}
+
+	prev := ""
+	for y := goedit.cursor.y - 1; y >= 0; y-- {
+		if strings.TrimSpace(goedit.rows[y].text()) != "" {
+			prev = goedit.rows[y].text()
+			break
+		}
+	}
+
+	indent := leadingWhitespace(prev)
+	if !opensBlock(prev) {
+		indent = goedit.indent.dedent(indent)
+	}
+
+	if indent == ws {
+		return
+	}
+
+	row.setChars(indent + rest)
+	goedit.cursor.x += len(indent) - len(ws)
+}
+
+// editorInsertKey inserts a key typed in insert mode, expanding tabs and
+// reindenting the row as the indent options ask.
+func editorInsertKey(c rune) {
+	if c == '\t' {
+		editorInsertTab()
+		return
+	}
+
+	editorInsertRune(c)
+	editorReindentLine(c)
+}
+
+// editorInsertTab moves the cursor to the next softtabstop, or tabstop when
+// softtabstop is 0, using spaces when expandtab is set.
+func editorInsertTab() {
+	o := goedit.indent
+	if !o.ExpandTab && o.SoftTabStop == 0 {
+		editorInsertRune('\t')
+		return
+	}
+
+	width := o.SoftTabStop
+	if width == 0 {
+		width = o.TabStop
+	}
+
+	if goedit.cursor.y == goedit.numOfRows {
+		goedit.insertRow(goedit.numOfRows, "")
+	}
+
+	row := &goedit.rows[goedit.cursor.y]
+	col := cursorxToRx(*row, goedit.cursor.x)
+	for i := 0; i < width-col%width; i++ {
+		editorInsertRune(' ')
+	}
+
+	if !o.ExpandTab {
+		editorRetabBeforeCursor()
+	}
+}
+
+// editorRetabBeforeCursor replaces the run of blanks before the cursor with
+// as many tabs as fit, followed by spaces.
+func editorRetabBeforeCursor() {
+	row := &goedit.rows[goedit.cursor.y]
+	text := row.text()
+	start := len(strings.TrimRight(text[:goedit.cursor.x], " \t"))
+
+	startCol := cursorxToRx(*row, start)
+	endCol := cursorxToRx(*row, goedit.cursor.x)
+	ts := goedit.indent.TabStop
+
+	blanks := ""
+	col := startCol
+	for col+ts-col%ts <= endCol {
+		blanks += "\t"
+		col += ts - col%ts
+	}
+	blanks += strings.Repeat(" ", endCol-col)
+
+	row.setChars(text[:start] + blanks + text[goedit.cursor.x:])
+	goedit.cursor.x = start + len(blanks)
+}
+
+// editorDelSoftTab deletes backwards to the previous softtabstop when only
+// spaces lie between it and the cursor, and a single rune otherwise.
+func editorDelSoftTab() {
+	sts := goedit.indent.SoftTabStop
+	if sts == 0 || goedit.cursor.y >= goedit.numOfRows || goedit.cursor.x == 0 {
+		editorDelRune()
+		return
+	}
+
+	row := goedit.rows[goedit.cursor.y]
+	col := cursorxToRx(row, goedit.cursor.x)
+	n := col % sts
+	if n == 0 {
+		n = sts
+	}
+
+	for i := 0; i < n && goedit.cursor.x > 0; i++ {
+		if row.chars[goedit.cursor.x-1] != ' ' {
+			if i == 0 {
+				editorDelRune()
+			}
+			return
+		}
+		editorDelRune()
+	}
+}
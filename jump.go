@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+)
+
+// MAX_JUMPS is how many positions the jump list keeps, as in vim.
+const MAX_JUMPS = 100
+
+// jump is a position in the jump list. As the editor holds one buffer at a
+// time, going back to a jump in another file opens that file again.
+type jump struct {
+	filename string
+	pos      cursor
+}
+
+// currentJump returns the cursor position as a jump list entry.
+func currentJump() jump {
+	return jump{filename: goedit.filename, pos: goedit.cursor}
+}
+
+// pushJump records j, the position before a jump, dropping the positions
+// Ctrl-I could still have gone forward to.
+func pushJump(j jump) {
+	goedit.jumps = append(goedit.jumps[:goedit.jumpIdx], j)
+	if len(goedit.jumps) > MAX_JUMPS {
+		goedit.jumps = goedit.jumps[len(goedit.jumps)-MAX_JUMPS:]
+	}
+	goedit.jumpIdx = len(goedit.jumps)
+}
+
+// editorJump implements Ctrl-O, going back to the previous position in the
+// jump list, and with back unset Ctrl-I, going forward again.
+func editorJump(back bool) error {
+	target := goedit.jumpIdx + 1
+	if back {
+		if goedit.jumpIdx == 0 {
+			return fmt.Errorf("at the start of the jump list")
+		}
+		// Remember where Ctrl-O was typed, for Ctrl-I to come back to.
+		if goedit.jumpIdx == len(goedit.jumps) {
+			goedit.jumps = append(goedit.jumps, currentJump())
+		}
+		target = goedit.jumpIdx - 1
+	}
+	if target >= len(goedit.jumps) {
+		return fmt.Errorf("at the end of the jump list")
+	}
+
+	j := goedit.jumps[target]
+	if j.filename != goedit.filename && !sameFile(j.filename, goedit.filename) {
+		if j.filename == "" {
+			return exErr(32, "No file name", "")
+		}
+		editorOfferWrite(j.filename)
+		if err := editorEditFile(j.filename, false); err != nil {
+			return err
+		}
+	}
+	goedit.jumpIdx = target
+
+	editorGotoLine(j.pos.y + 1)
+	if goedit.cursor.y < goedit.numOfRows && j.pos.x < goedit.rows[goedit.cursor.y].size {
+		goedit.cursor.x = j.pos.x
+	}
+
+	return nil
+}
+
+// editorGotoDeclaration moves to e for gd. When e is in another file and the
+// buffer is modified, it offers to write the buffer first, as the editor
+// cannot keep both.
+func editorGotoDeclaration(e qfEntry) error {
+	if e.filename != "" && !sameFile(e.filename, goedit.filename) {
+		editorOfferWrite(e.filename)
+	}
+
+	return editorGotoEntry(e, false)
+}
+
+// editorOfferWrite asks whether to write the modified buffer before filename
+// is opened in its place, and writes it when the answer is y. Otherwise the
+// buffer stays modified, and opening filename fails with E37.
+func editorOfferWrite(filename string) {
+	if !goedit.modifiyed || goedit.executingKeys {
+		return
+	}
+
+	editorMessage(fmt.Sprintf("Write \"%s\" to open \"%s\"? (y/n)", goedit.filename, relativePath(filename)))
+	clearScreen()
+	if readKey() == 'y' {
+		if err := goedit.save(); err != nil {
+			editorError(err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestJumpList(t *testing.T) {
+	defer func() { goedit.rows, goedit.numOfRows, goedit.jumps, goedit.jumpIdx = nil, 0, nil, 0 }()
+	goedit.rows = testRows("one", "two", "three", "four")
+	goedit.numOfRows = len(goedit.rows)
+	goedit.jumps, goedit.jumpIdx = nil, 0
+
+	goto_ := func(y int, x int) {
+		if err := editorGotoEntry(qfEntry{line: y + 1, col: x + 1, valid: true}, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	at := func(op string, y int, x int) {
+		t.Helper()
+		if goedit.cursor != (cursor{x: x, y: y}) {
+			t.Errorf("after %s cursor = %+v, want %d,%d", op, goedit.cursor, y, x)
+		}
+	}
+
+	goedit.cursor = cursor{x: 1, y: 0}
+	goto_(2, 2)
+	goto_(3, 0)
+
+	editorJump(true)
+	at("first Ctrl-O", 2, 2)
+	editorJump(true)
+	at("second Ctrl-O", 0, 1)
+	if err := editorJump(true); err == nil {
+		t.Error("Ctrl-O at the start of the jump list succeeded")
+	}
+
+	editorJump(false)
+	at("Ctrl-I", 2, 2)
+	editorJump(false)
+	at("second Ctrl-I", 3, 0)
+	if err := editorJump(false); err == nil {
+		t.Error("Ctrl-I at the end of the jump list succeeded")
+	}
+
+	// A new jump drops the positions Ctrl-I could have gone to.
+	editorJump(true)
+	editorJump(true)
+	goto_(1, 0)
+	if err := editorJump(false); err == nil {
+		t.Error("Ctrl-I after a new jump succeeded")
+	}
+	editorJump(true)
+	at("Ctrl-O after a new jump", 0, 1)
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MAX_MAP_DEPTH bounds how many mappings may expand without a key being used,
+// to stop recursive mappings from hanging the editor.
+const MAX_MAP_DEPTH = 1000
+
+// typedKey is a key waiting to be read. Keys produced by a noremap mapping are
+// not mapped again.
+type typedKey struct {
+	key     rune
+	noremap bool
+}
+
+type mapping struct {
+	lhs     []rune
+	rhs     []rune
+	noremap bool
+}
+
+var keymaps = map[int][]mapping{}
+
+var keyNames = map[string]rune{
+	"cr":       '\r',
+	"enter":    '\r',
+	"return":   '\r',
+	"esc":      '\x1b',
+	"tab":      '\t',
+	"space":    ' ',
+	"bs":       BACKSPACE,
+	"lt":       '<',
+	"bar":      '|',
+	"bslash":   '\\',
+	"up":       CURSOR_UP,
+	"down":     CURSOR_DOWN,
+	"left":     CURSOR_LEFT,
+	"right":    CURSOR_RIGHT,
+	"pageup":   PAGE_UP,
+	"pagedown": PAGE_DOWN,
+	"home":     HOME_KEY,
+	"end":      END_KEY,
+	"del":      DEL_KEY,
+}
+
+// nextKey returns the next key of typeahead, or reads one from the terminal,
+// waiting up to timeout or forever when timeout is negative.
+func nextKey(timeout time.Duration) (typedKey, bool) {
+	if len(goedit.typeahead) > 0 {
+		key := goedit.typeahead[0]
+		goedit.typeahead = goedit.typeahead[1:]
+		return key, true
+	}
+
+	if goedit.executingKeys {
+		// Commands run by :normal that want more keys than were given
+		// see an escape, as if the user had cancelled them.
+		return typedKey{key: '\x1b'}, false
+	}
+
+	key, ok := readTerminalKey(timeout)
+	return typedKey{key: key}, ok
+}
+
+// pushTypeahead puts keys back in front of any pending typeahead.
+func pushTypeahead(keys []typedKey) {
+	goedit.typeahead = append(append([]typedKey{}, keys...), goedit.typeahead...)
+}
+
+// readMappedKey reads the next key for mode, replacing any sequence of keys
+// that matches a mapping by its right hand side. While the keys read so far
+// could still become a longer mapping it waits up to timeoutlen for more.
+func readMappedKey(mode int) rune {
+	for depth := 0; ; depth++ {
+		first, _ := nextKey(-1)
+		if first.noremap || len(keymaps[mode]) == 0 {
+			return first.key
+		}
+
+		seq := []typedKey{first}
+		for hasLongerMapping(mode, seq) {
+			timeout := time.Duration(-1)
+			if goedit.timeout {
+				timeout = time.Duration(goedit.timeoutLen) * time.Millisecond
+			}
+
+			key, ok := nextKey(timeout)
+			if !ok {
+				break
+			}
+			seq = append(seq, key)
+		}
+
+		m := longestMapping(mode, seq)
+		if m == nil {
+			pushTypeahead(seq[1:])
+			return first.key
+		}
+
+		if depth >= MAX_MAP_DEPTH {
+			goedit.typeahead = nil
+			editorError(exErr(223, "recursive mapping", ""))
+			return first.key
+		}
+
+		var keys []typedKey
+		for i, r := range m.rhs {
+			// Like vim, a right hand side starting with its own left hand side
+			// does not map that part again.
+			prefix := i < len(m.lhs) && runesHasPrefix(m.rhs, m.lhs)
+			keys = append(keys, typedKey{key: r, noremap: m.noremap || prefix})
+		}
+		pushTypeahead(append(keys, seq[len(m.lhs):]...))
+	}
+}
+
+func runesHasPrefix(s []rune, prefix []rune) bool {
+	if len(prefix) > len(s) {
+		return false
+	}
+
+	for i := range prefix {
+		if s[i] != prefix[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func typedRunes(keys []typedKey) []rune {
+	runes := make([]rune, len(keys))
+	for i, k := range keys {
+		runes[i] = k.key
+	}
+
+	return runes
+}
+
+// hasLongerMapping reports whether some mapping of mode starts with, but is
+// longer than, seq.
+func hasLongerMapping(mode int, seq []typedKey) bool {
+	keys := typedRunes(seq)
+	for _, m := range keymaps[mode] {
+		if len(m.lhs) > len(keys) && runesHasPrefix(m.lhs, keys) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// longestMapping returns the mapping of mode with the longest left hand side
+// that seq starts with.
+func longestMapping(mode int, seq []typedKey) *mapping {
+	keys := typedRunes(seq)
+	var best *mapping
+	for i, m := range keymaps[mode] {
+		if runesHasPrefix(keys, m.lhs) && (best == nil || len(m.lhs) > len(best.lhs)) {
+			best = &keymaps[mode][i]
+		}
+	}
+
+	return best
+}
+
+// parseKeys turns key notation such as "<leader>w" or "<C-s>" into keys.
+// Unknown <...> names are taken literally.
+func parseKeys(s string) []rune {
+	var keys []rune
+	for len(s) > 0 {
+		if s[0] == '<' {
+			if end := strings.IndexByte(s, '>'); end > 1 {
+				name := strings.ToLower(s[1:end])
+				if k, ok := keyNames[name]; ok {
+					keys = append(keys, k)
+					s = s[end+1:]
+					continue
+				}
+
+				if name == "leader" {
+					keys = append(keys, []rune(goedit.mapLeader)...)
+					s = s[end+1:]
+					continue
+				}
+
+				if len(name) == 3 && strings.HasPrefix(name, "c-") && name[2] >= 'a' && name[2] <= 'z' {
+					keys = append(keys, rune(name[2]-'a'+1))
+					s = s[end+1:]
+					continue
+				}
+			}
+		}
+
+		r := []rune(s)[0]
+		keys = append(keys, r)
+		s = s[len(string(r)):]
+	}
+
+	return keys
+}
+
+var keyDisplayNames = map[rune]string{
+	'\r':         "<CR>",
+	'\x1b':       "<Esc>",
+	'\t':         "<Tab>",
+	' ':          "<Space>",
+	BACKSPACE:    "<BS>",
+	CURSOR_UP:    "<Up>",
+	CURSOR_DOWN:  "<Down>",
+	CURSOR_LEFT:  "<Left>",
+	CURSOR_RIGHT: "<Right>",
+	PAGE_UP:      "<PageUp>",
+	PAGE_DOWN:    "<PageDown>",
+	HOME_KEY:     "<Home>",
+	END_KEY:      "<End>",
+	DEL_KEY:      "<Del>",
+}
+
+// keysString is the inverse of parseKeys, used to list mappings.
+func keysString(keys []rune) string {
+	buf := strings.Builder{}
+	for _, k := range keys {
+		name, ok := keyDisplayNames[k]
+		switch {
+		case ok:
+			buf.WriteString(name)
+		case k > 0 && k < 27:
+			buf.WriteString(fmt.Sprintf("<C-%c>", 'A'+k-1))
+		default:
+			buf.WriteRune(k)
+		}
+	}
+
+	return buf.String()
+}
+
+// mapCommandMode returns the mode a :map style command applies to and
+// whether it is a noremap command. With ! :map and :noremap apply to insert
+// mode.
+func mapCommandMode(name string, bang bool) (int, bool) {
+	noremap := strings.HasSuffix(name, "noremap")
+	if bang || strings.HasPrefix(name, "i") {
+		return INSERT_MODE, noremap
+	}
+
+	return NORMAL_MODE, noremap
+}
+
+// editorMapCommand defines a mapping, or lists the mappings starting with the
+// given keys when there is no right hand side.
+func editorMapCommand(name string, bang bool, args string) error {
+	mode, noremap := mapCommandMode(name, bang)
+	lhs, rhs, _ := strings.Cut(args, " ")
+	rhs = strings.TrimLeft(rhs, " \t")
+
+	if rhs == "" {
+		editorListMappings(mode, parseKeys(lhs))
+		return nil
+	}
+
+	m := mapping{lhs: parseKeys(lhs), rhs: parseKeys(rhs), noremap: noremap}
+	removeMapping(mode, m.lhs)
+	keymaps[mode] = append(keymaps[mode], m)
+
+	return nil
+}
+
+// editorUnmapCommand removes the mapping for the given keys.
+func editorUnmapCommand(name string, bang bool, args string) error {
+	if args == "" {
+		return exErr(474, "Invalid argument", "")
+	}
+
+	mode, _ := mapCommandMode(name, bang)
+	if !removeMapping(mode, parseKeys(args)) {
+		return exErr(31, "No such mapping", args)
+	}
+
+	return nil
+}
+
+func removeMapping(mode int, lhs []rune) bool {
+	for i, m := range keymaps[mode] {
+		if string(m.lhs) == string(lhs) {
+			keymaps[mode] = append(keymaps[mode][:i], keymaps[mode][i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func editorListMappings(mode int, prefix []rune) {
+	modeName := "n"
+	if mode == INSERT_MODE {
+		modeName = "i"
+	}
+
+	var lines []string
+	for _, m := range keymaps[mode] {
+		if !runesHasPrefix(m.lhs, prefix) {
+			continue
+		}
+
+		star := " "
+		if m.noremap {
+			star = "*"
+		}
+		lines = append(lines, fmt.Sprintf("%s  %-12s %s %s", modeName, keysString(m.lhs), star, keysString(m.rhs)))
+	}
+
+	if len(lines) == 0 {
+		editorMessage("No mapping found")
+		return
+	}
+
+	sort.Strings(lines)
+	editorShowLines(lines)
+}
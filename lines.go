@@ -0,0 +1,406 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// replaceLines replaces rows first to last (0-based, inclusive) with lines.
+// With last = first-1 the lines are inserted before row first.
+func replaceLines(first int, last int, lines []string) {
+	rows := make([]erow, len(lines))
+	for i, text := range lines {
+		rows[i].setChars(text)
+	}
+
+	goedit.rows = append(goedit.rows[:first], append(rows, goedit.rows[last+1:]...)...)
+	goedit.numOfRows = len(goedit.rows)
+	goedit.modifiyed = true
+	goedit.updateGutter()
+}
+
+func rangeLines(first int, last int) []string {
+	var lines []string
+	for y := first; y <= last && y < goedit.numOfRows; y++ {
+		lines = append(lines, goedit.rows[y].text())
+	}
+
+	return lines
+}
+
+// applyCount handles a trailing [count] argument: the range becomes count
+// lines starting at its last line.
+func (c *exCmd) applyCount(arg string) error {
+	if arg == "" {
+		return nil
+	}
+
+	count, err := strconv.Atoi(arg)
+	if err != nil || count <= 0 {
+		return exErr(488, "Trailing characters", arg)
+	}
+
+	c.line1 = c.line2
+	c.line2 = c.line1 + count - 1
+	if c.line2 > goedit.numOfRows {
+		c.line2 = goedit.numOfRows
+	}
+
+	return nil
+}
+
+// parseDestination parses the address :m and :t put lines below, where 0
+// means above the first line.
+func parseDestination(arg string) (int, error) {
+	p := exParser{text: arg}
+	line, ok, err := p.parseAddress(goedit.cursor.y + 1)
+	if err != nil {
+		return 0, err
+	}
+
+	if !ok {
+		return 0, exErr(14, "Invalid address", "")
+	}
+
+	if p.skipBlanks(); p.pos < len(p.text) {
+		return 0, exErr(488, "Trailing characters", p.text[p.pos:])
+	}
+
+	if line < 0 || line > goedit.numOfRows {
+		return 0, exErr(16, "Invalid range", "")
+	}
+
+	return line, nil
+}
+
+// reportLines shows msg when more than two lines were affected, as vim does
+// with its default 'report'.
+func reportLines(n int, msg string) {
+	if n > 2 {
+		editorMessage(msg)
+	}
+}
+
+// exDelete implements :[range]d[elete] [count].
+func exDelete(c *exCmd) error {
+	if err := c.applyCount(c.arg); err != nil {
+		return err
+	}
+
+	if goedit.numOfRows == 0 {
+		return nil
+	}
+
+	saveUndo()
+	n := c.line2 - c.line1 + 1
+	replaceLines(c.line1-1, c.line2-1, nil)
+	editorGotoLine(c.line1)
+	reportLines(n, fmt.Sprintf("%d fewer lines", n))
+
+	return nil
+}
+
+// exMove implements :[range]m[ove] {address}. The rows themselves move, so
+// lines marked by :g stay marked.
+func exMove(c *exCmd) error {
+	dest, err := parseDestination(c.arg)
+	if err != nil {
+		return err
+	}
+
+	if dest >= c.line1 && dest < c.line2 {
+		return exErr(134, "Cannot move a range of lines into itself", "")
+	}
+
+	if goedit.numOfRows == 0 || dest == c.line2 || dest == c.line1-1 {
+		return nil
+	}
+
+	saveUndo()
+	moved := append([]erow{}, goedit.rows[c.line1-1:c.line2]...)
+	n := len(moved)
+	goedit.rows = append(goedit.rows[:c.line1-1], goedit.rows[c.line2:]...)
+	if dest >= c.line2 {
+		dest -= n
+	}
+	goedit.rows = append(goedit.rows[:dest], append(moved, goedit.rows[dest:]...)...)
+	goedit.modifiyed = true
+
+	editorGotoLine(dest + n)
+	reportLines(n, fmt.Sprintf("%d lines moved", n))
+
+	return nil
+}
+
+// exCopy implements :[range]co[py] {address} and its synonym :t.
+func exCopy(c *exCmd) error {
+	dest, err := parseDestination(c.arg)
+	if err != nil {
+		return err
+	}
+
+	if goedit.numOfRows == 0 {
+		return nil
+	}
+
+	saveUndo()
+	lines := rangeLines(c.line1-1, c.line2-1)
+	replaceLines(dest, dest-1, lines)
+	editorGotoLine(dest + len(lines))
+	reportLines(len(lines), fmt.Sprintf("%d more lines", len(lines)))
+
+	return nil
+}
+
+// exJoin implements :[range]j[oin][!] [count]. Without a range it joins the
+// cursor line with the next one; with ! no blanks are added or removed.
+func exJoin(c *exCmd) error {
+	if c.arg != "" {
+		if err := c.applyCount(c.arg); err != nil {
+			return err
+		}
+	} else if c.addrs < 2 {
+		c.line2 = c.line1 + 1
+	}
+
+	if c.line2 > goedit.numOfRows {
+		c.line2 = goedit.numOfRows
+	}
+
+	if c.line1 >= c.line2 {
+		return nil
+	}
+
+	saveUndo()
+	text, col := goedit.rows[c.line1-1].text(), 0
+	for y := c.line1; y < c.line2; y++ {
+		text, col = joinLines(text, goedit.rows[y].text(), !c.bang)
+	}
+
+	replaceLines(c.line1-1, c.line2-1, []string{text})
+	goedit.cursor = cursor{x: col, y: c.line1 - 1}
+	goedit.moveCursor(0)
+
+	return nil
+}
+
+// joinLines appends next to line. With spaces set the leading blanks of next
+// are replaced by a single space, or none when line already ends in a blank
+// or next starts with ')'. It also returns where the two parts meet.
+func joinLines(line string, next string, spaces bool) (string, int) {
+	if !spaces {
+		return line + next, len(line)
+	}
+
+	next = strings.TrimLeft(next, " \t")
+	if line == "" || next == "" || strings.HasSuffix(line, " ") || strings.HasSuffix(line, "\t") || next[0] == ')' {
+		return line + next, len(line)
+	}
+
+	return line + " " + next, len(line)
+}
+
+// exShift implements :[range]> and :[range]<, shifting the non-blank lines by
+// a shiftwidth for each > or < given, as in ":>>" or ":<<< 3".
+func exShift(c *exCmd) error {
+	arg, times := c.arg, 1
+	for arg != "" && arg[0] == c.def.name[0] {
+		times++
+		arg = strings.TrimLeft(arg[1:], " \t")
+	}
+
+	if err := c.applyCount(arg); err != nil {
+		return err
+	}
+
+	width := times * goedit.indent.shiftWidth()
+	if c.def.name == "<" {
+		width = -width
+	}
+
+	snapshot := undoSnapshot()
+	changed := false
+	for y := c.line1 - 1; y < c.line2 && y < goedit.numOfRows; y++ {
+		text := goedit.rows[y].text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		ws := leadingWhitespace(text)
+		cols := goedit.indent.indentWidth(ws) + width
+		if cols < 0 {
+			cols = 0
+		}
+
+		if indent := goedit.indent.makeIndent(cols); indent != ws {
+			goedit.rows[y].setChars(indent + text[len(ws):])
+			changed = true
+		}
+	}
+
+	if changed {
+		pushUndo(snapshot)
+		goedit.modifiyed = true
+	}
+
+	editorGotoLine(c.line2)
+	n := c.line2 - c.line1 + 1
+	reportLines(n, fmt.Sprintf("%d lines %sed %d time%s", n, c.def.name, times, plural(times)))
+
+	return nil
+}
+
+// sortLine is a line being sorted by :sort along with the key it sorts on.
+type sortLine struct {
+	text   string
+	key    string
+	num    int64
+	hasNum bool
+}
+
+// exSort implements :[range]sort[!] [i][n][x][u][r] [/pattern/]. Lines sort
+// on what follows the first match of pattern, or on the match itself with r.
+// With n or x they sort on the first decimal or hexadecimal number in the
+// key, lines without one coming first in their original order. ! reverses
+// the order and u keeps only the first of lines that compare equal.
+func exSort(c *exCmd) error {
+	var ignore, numeric, hex, unique, useMatch bool
+	var re *regexp.Regexp
+
+	arg := c.arg
+	for i := 0; i < len(arg); i++ {
+		switch ch := arg[i]; {
+		case ch == ' ' || ch == '\t':
+		case ch == 'i':
+			ignore = true
+		case ch == 'n':
+			numeric = true
+		case ch == 'x':
+			hex = true
+		case ch == 'u':
+			unique = true
+		case ch == 'r':
+			useMatch = true
+		case isSubstituteDelim(ch):
+			pattern, rest := splitDelimited(arg[i+1:], ch)
+			if pattern == "" {
+				pattern = goedit.search.query
+			}
+
+			if pattern == "" {
+				return exErr(35, "No previous regular expression", "")
+			}
+
+			var err error
+			if re, err = compilePattern(pattern); err != nil {
+				return err
+			}
+			goedit.search.query = pattern
+
+			arg, i = rest, -1
+		default:
+			return exErr(474, "Invalid argument", arg[i:])
+		}
+	}
+
+	numRe := regexp.MustCompile(`-?\d+`)
+	if hex {
+		numRe = regexp.MustCompile(`-?(0[xX])?[0-9a-fA-F]+`)
+	}
+
+	var lines []sortLine
+	for _, text := range rangeLines(c.line1-1, c.line2-1) {
+		l := sortLine{text: text, key: text}
+		if re != nil {
+			m := re.FindStringIndex(text)
+			switch {
+			case m != nil && useMatch:
+				l.key = text[m[0]:m[1]]
+			case m != nil:
+				l.key = text[m[1]:]
+			case useMatch:
+				l.key = ""
+			}
+		}
+
+		if ignore {
+			l.key = strings.ToLower(l.key)
+		}
+
+		if numeric || hex {
+			if s := numRe.FindString(l.key); s != "" {
+				l.num, l.hasNum = parseSortNumber(s, hex), true
+			}
+		}
+
+		lines = append(lines, l)
+	}
+
+	compare := func(a, b sortLine) int {
+		if !numeric && !hex {
+			return strings.Compare(a.key, b.key)
+		}
+
+		switch {
+		case a.hasNum != b.hasNum && a.hasNum:
+			return 1
+		case a.hasNum != b.hasNum:
+			return -1
+		case a.num < b.num:
+			return -1
+		case a.num > b.num:
+			return 1
+		}
+		return 0
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		if c.bang {
+			return compare(lines[j], lines[i]) < 0
+		}
+		return compare(lines[i], lines[j]) < 0
+	})
+
+	var sorted []string
+	for i, l := range lines {
+		if unique && i > 0 && compare(lines[i-1], l) == 0 {
+			continue
+		}
+		sorted = append(sorted, l.text)
+	}
+
+	if strings.Join(sorted, "\n") == strings.Join(rangeLines(c.line1-1, c.line2-1), "\n") {
+		return nil
+	}
+
+	saveUndo()
+	replaceLines(c.line1-1, c.line2-1, sorted)
+	editorGotoLine(c.line1)
+
+	if n := len(lines) - len(sorted); n > 0 {
+		reportLines(n, fmt.Sprintf("%d fewer lines", n))
+	}
+
+	return nil
+}
+
+func parseSortNumber(s string, hex bool) int64 {
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	base := 10
+	if hex {
+		base = 16
+		s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	}
+
+	n, _ := strconv.ParseInt(s, base, 64)
+	if neg {
+		return -n
+	}
+
+	return n
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LSP_TIMEOUT is how long a request to the language server may take.
+const LSP_TIMEOUT = 5 * time.Second
+
+// lspPosition is a position in a document: a 0-based line and a column in
+// UTF-16 code units.
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+// lspDiagnostic is an error or warning the server reports for a document.
+// Severity is 1 for an error, 2 a warning, 3 information and 4 a hint.
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspCompletionItem struct {
+	Label         string          `json:"label"`
+	Kind          int             `json:"kind"`
+	Detail        string          `json:"detail"`
+	Documentation json.RawMessage `json:"documentation"`
+	InsertText    string          `json:"insertText"`
+	TextEdit      *lspTextEdit    `json:"textEdit"`
+}
+
+// lspMessage is a JSON-RPC request, response or notification.
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *lspError) Error() string {
+	return fmt.Sprintf("language server: %s", e.Message)
+}
+
+// lspClient speaks JSON-RPC with a language server over a pair of streams,
+// normally the standard input and output of the server process. Responses
+// are matched to the calls waiting for them; notifications are queued and
+// handed to notify one at a time on a goroutine of their own, so a slow
+// handler never holds up the reading of responses.
+type lspClient struct {
+	in      *bufio.Reader
+	out     io.Writer
+	notify  func(method string, params json.RawMessage)
+	timeout time.Duration
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]chan lspMessage
+	notes   []lspMessage
+	wake    chan bool
+	closed  bool
+	err     error
+}
+
+// newLSPClient starts a client reading messages from r and writing them to w.
+func newLSPClient(r io.Reader, w io.Writer, notify func(method string, params json.RawMessage)) *lspClient {
+	c := &lspClient{
+		in:      bufio.NewReader(r),
+		out:     w,
+		notify:  notify,
+		timeout: LSP_TIMEOUT,
+		pending: map[int]chan lspMessage{},
+		wake:    make(chan bool, 1),
+	}
+
+	go c.readLoop()
+	go c.notifyLoop()
+
+	return c
+}
+
+// notifyLoop hands the queued notifications to notify until the stream ends.
+func (c *lspClient) notifyLoop() {
+	for range c.wake {
+		for {
+			c.mu.Lock()
+			notes, closed := c.notes, c.closed
+			c.notes = nil
+			c.mu.Unlock()
+
+			for _, msg := range notes {
+				if c.notify != nil {
+					c.notify(msg.Method, msg.Params)
+				}
+			}
+			if closed {
+				return
+			}
+			if len(notes) == 0 {
+				break
+			}
+		}
+	}
+}
+
+// signal wakes notifyLoop unless it has been woken already.
+func (c *lspClient) signal() {
+	select {
+	case c.wake <- true:
+	default:
+	}
+}
+
+// write sends msg with the Content-Length header the protocol frames
+// messages with.
+func (c *lspClient) write(msg lspMessage) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := fmt.Fprintf(c.out, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = c.out.Write(body)
+	return err
+}
+
+// read reads the next message from the server.
+func (c *lspClient) read() (lspMessage, error) {
+	length := -1
+	for {
+		line, err := c.in.ReadString('\n')
+		if err != nil {
+			return lspMessage{}, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return lspMessage{}, fmt.Errorf("bad Content-Length %q", value)
+			}
+		}
+	}
+
+	if length < 0 {
+		return lspMessage{}, fmt.Errorf("message without Content-Length")
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.in, body); err != nil {
+		return lspMessage{}, err
+	}
+
+	var msg lspMessage
+	err := json.Unmarshal(body, &msg)
+	return msg, err
+}
+
+// readLoop dispatches the messages from the server until the stream ends,
+// then fails the calls still waiting.
+func (c *lspClient) readLoop() {
+	for {
+		msg, err := c.read()
+		if err != nil {
+			c.mu.Lock()
+			c.err = fmt.Errorf("language server: %v", err)
+			for id, ch := range c.pending {
+				close(ch)
+				delete(c.pending, id)
+			}
+			c.closed = true
+			c.mu.Unlock()
+			c.signal()
+			return
+		}
+
+		switch {
+		case msg.ID != nil && msg.Method != "":
+			c.reply(msg)
+		case msg.ID != nil:
+			var id int
+			if json.Unmarshal(*msg.ID, &id) != nil {
+				continue
+			}
+
+			c.mu.Lock()
+			ch := c.pending[id]
+			delete(c.pending, id)
+			c.mu.Unlock()
+			if ch != nil {
+				ch <- msg
+			}
+		default:
+			c.mu.Lock()
+			c.notes = append(c.notes, msg)
+			c.mu.Unlock()
+			c.signal()
+		}
+	}
+}
+
+// reply answers a request from the server. Only workspace/configuration
+// gets a real answer, one empty setting per item; the others get null.
+func (c *lspClient) reply(req lspMessage) {
+	result := json.RawMessage("null")
+	if req.Method == "workspace/configuration" {
+		var params struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		json.Unmarshal(req.Params, &params)
+		result = json.RawMessage("[" + strings.TrimSuffix(strings.Repeat("null,", len(params.Items)), ",") + "]")
+	}
+
+	c.write(lspMessage{ID: req.ID, Result: result})
+}
+
+// call sends a request and waits up to c.timeout for the response, decoding
+// its result into result unless that is nil.
+func (c *lspClient) call(method string, params interface{}, result interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan lspMessage, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	rawID := json.RawMessage(strconv.Itoa(id))
+	if err := c.write(lspMessage{ID: &rawID, Method: method, Params: raw}); err != nil {
+		return err
+	}
+
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			return c.err
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result != nil && len(msg.Result) > 0 {
+			return json.Unmarshal(msg.Result, result)
+		}
+		return nil
+	case <-time.After(c.timeout):
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return fmt.Errorf("language server: %s timed out", method)
+	}
+}
+
+// notification sends a notification, which gets no response.
+func (c *lspClient) notification(method string, params interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	return c.write(lspMessage{Method: method, Params: raw})
+}
+
+// fileURI returns the file: URI for filename.
+func fileURI(filename string) string {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		abs = filename
+	}
+
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}
+	return u.String()
+}
+
+// uriFile returns the file name a file: URI names, relative to the working
+// directory when it is below it.
+func uriFile(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+
+	return relativePath(filepath.FromSlash(u.Path))
+}
+
+// relativePath returns path relative to the working directory when it is
+// below it.
+func relativePath(path string) string {
+	if wd, err := filepath.Abs("."); err == nil {
+		if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+
+	return path
+}
+
+// utf16Col converts the byte offset x in line to UTF-16 code units.
+func utf16Col(line string, x int) int {
+	col := 0
+	for i, r := range line {
+		if i >= x {
+			break
+		}
+		col++
+		if r >= 0x10000 {
+			col++
+		}
+	}
+
+	return col
+}
+
+// byteCol converts the UTF-16 column col in line to a byte offset.
+func byteCol(line string, col int) int {
+	for i, r := range line {
+		if col <= 0 {
+			return i
+		}
+		col--
+		if r >= 0x10000 {
+			col--
+		}
+	}
+
+	return len(line)
+}
+
+// applyTextEdits applies edits, given in LSP positions, to text.
+func applyTextEdits(text string, edits []lspTextEdit) string {
+	lines := strings.SplitAfter(text, "\n")
+	offset := func(p lspPosition) int {
+		off := 0
+		for i := 0; i < p.Line && i < len(lines); i++ {
+			off += len(lines[i])
+		}
+		if p.Line < len(lines) {
+			off += byteCol(strings.TrimSuffix(lines[p.Line], "\n"), p.Character)
+		}
+		return off
+	}
+
+	type span struct {
+		start, end int
+		text       string
+		index      int
+	}
+
+	spans := make([]span, len(edits))
+	for i, e := range edits {
+		spans[i] = span{offset(e.Range.Start), offset(e.Range.End), e.NewText, i}
+	}
+
+	// Apply from the end so earlier offsets stay valid. Edits starting at the
+	// same place go in backwards too, which leaves their texts in the order
+	// the server gave them.
+	sort.Slice(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start > spans[j].start
+		}
+		return spans[i].index > spans[j].index
+	})
+
+	for _, s := range spans {
+		if s.start <= s.end && s.end <= len(text) {
+			text = text[:s.start] + s.text + text[s.end:]
+		}
+	}
+
+	return text
+}
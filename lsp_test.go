@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServer is the other end of the streams of a client under test.
+type fakeServer struct {
+	conn *lspClient
+	in   io.ReadCloser
+	out  io.WriteCloser
+}
+
+// newFakeServer connects a client to a fake server over a pair of pipes.
+func newFakeServer(notify func(method string, params json.RawMessage)) (*lspClient, *fakeServer) {
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+
+	s := &fakeServer{in: serverIn, out: serverOut}
+	// The server side only reads and writes messages, so a client that is
+	// never started frames them for it.
+	s.conn = &lspClient{in: bufio.NewReader(serverIn), out: serverOut}
+
+	return newLSPClient(clientIn, clientOut, notify), s
+}
+
+func (s *fakeServer) read(t *testing.T) lspMessage {
+	t.Helper()
+	msg, err := s.conn.read()
+	if err != nil {
+		t.Fatalf("server read: %v", err)
+	}
+
+	return msg
+}
+
+func (s *fakeServer) respond(id *json.RawMessage, result string) {
+	s.conn.write(lspMessage{ID: id, Result: json.RawMessage(result)})
+}
+
+func (s *fakeServer) close() {
+	s.out.Close()
+	s.in.Close()
+}
+
+func TestLSPFraming(t *testing.T) {
+	c, s := newFakeServer(nil)
+	defer s.close()
+
+	go c.notification("initialized", map[string]int{"x": 1})
+
+	header, err := s.conn.in.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(header, "Content-Length: ") || !strings.HasSuffix(header, "\r\n") {
+		t.Fatalf("header = %q", header)
+	}
+	length, _ := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "Content-Length: ")))
+	if blank, _ := s.conn.in.ReadString('\n'); blank != "\r\n" {
+		t.Fatalf("line after the header = %q, want a blank line", blank)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.conn.in, body); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"jsonrpc":"2.0","method":"initialized","params":{"x":1}}`; string(body) != want {
+		t.Errorf("body = %s, want %s", body, want)
+	}
+
+	// A response split across writes, with a header the client ignores and
+	// the length in lower case.
+	done := make(chan error, 1)
+	var result string
+	go func() { done <- c.call("shutdown", nil, &result) }()
+	msg := s.read(t)
+	resp := fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":"ok"}`, *msg.ID)
+	for _, part := range []string{
+		"Content-Type: application/vscode-jsonrpc; charset=utf-8\r\ncontent-length: ",
+		strconv.Itoa(len(resp)) + "\r\n\r\n",
+		resp[:10],
+		resp[10:],
+	} {
+		io.WriteString(s.out, part)
+	}
+
+	if err := <-done; err != nil || result != "ok" {
+		t.Errorf("call = %q %v, want ok", result, err)
+	}
+}
+
+func TestLSPCallMatchesID(t *testing.T) {
+	c, s := newFakeServer(nil)
+	defer s.close()
+
+	results := make(chan string, 2)
+	for _, method := range []string{"first", "second"} {
+		method := method
+		go func() {
+			var result string
+			if err := c.call(method, nil, &result); err != nil {
+				result = err.Error()
+			}
+			results <- method + "=" + result
+		}()
+	}
+
+	// Answer in the opposite order the requests came in.
+	a, b := s.read(t), s.read(t)
+	s.respond(b.ID, strconv.Quote(b.Method))
+	s.respond(a.ID, strconv.Quote(a.Method))
+
+	got := map[string]bool{<-results: true, <-results: true}
+	if !got["first=first"] || !got["second=second"] {
+		t.Errorf("results = %v, want each call to get its own response", got)
+	}
+}
+
+func TestLSPConfigurationReply(t *testing.T) {
+	_, s := newFakeServer(nil)
+	defer s.close()
+
+	id := json.RawMessage("7")
+	s.conn.write(lspMessage{ID: &id, Method: "workspace/configuration", Params: json.RawMessage(`{"items":[{},{},{}]}`)})
+
+	msg := s.read(t)
+	if msg.ID == nil || string(*msg.ID) != "7" || msg.Method != "" {
+		t.Fatalf("reply = %+v, want the response to request 7", msg)
+	}
+	if string(msg.Result) != "[null,null,null]" {
+		t.Errorf("result = %s, want one null per item", msg.Result)
+	}
+
+	s.conn.write(lspMessage{ID: &id, Method: "client/registerCapability", Params: json.RawMessage(`{}`)})
+	if msg := s.read(t); string(msg.Result) != "null" {
+		t.Errorf("result of another request = %s, want null", msg.Result)
+	}
+}
+
+func TestLSPCallTimeout(t *testing.T) {
+	c, s := newFakeServer(nil)
+	defer s.close()
+	c.timeout = 20 * time.Millisecond
+
+	go s.conn.read()
+	err := c.call("textDocument/hover", nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("call = %v, want a timeout", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) != 0 {
+		t.Errorf("%d calls still pending after the timeout", len(c.pending))
+	}
+}
+
+func TestLSPStreamClosed(t *testing.T) {
+	notes := make(chan string, 1)
+	c, s := newFakeServer(func(method string, params json.RawMessage) {
+		notes <- method
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- c.call("textDocument/definition", nil, nil) }()
+	s.read(t)
+	s.conn.write(lspMessage{Method: "window/showMessage", Params: json.RawMessage(`{}`)})
+	s.close()
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "language server") {
+			t.Errorf("pending call = %v, want the stream's error", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending call not failed when the stream closed")
+	}
+
+	if err := c.call("shutdown", nil, nil); err == nil {
+		t.Error("call after the stream closed succeeded")
+	}
+	if method := <-notes; method != "window/showMessage" {
+		t.Errorf("notification = %q, want the one sent before closing", method)
+	}
+}
+
+func TestLSPSlowNotify(t *testing.T) {
+	release := make(chan bool)
+	c, s := newFakeServer(func(method string, params json.RawMessage) {
+		<-release
+	})
+	defer s.close()
+	defer close(release)
+
+	done := make(chan error, 1)
+	go func() { done <- c.call("textDocument/hover", nil, nil) }()
+	msg := s.read(t)
+	for i := 0; i < 1000; i++ {
+		s.conn.write(lspMessage{Method: "textDocument/publishDiagnostics", Params: json.RawMessage(`{}`)})
+	}
+	s.respond(msg.ID, "null")
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("call = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a notification handler that does not return held up a response")
+	}
+}
+
+func TestApplyTextEdits(t *testing.T) {
+	at := func(line, char int) lspPosition { return lspPosition{Line: line, Character: char} }
+	insert := func(p lspPosition, text string) lspTextEdit {
+		return lspTextEdit{Range: lspRange{Start: p, End: p}, NewText: text}
+	}
+
+	tests := []struct {
+		text  string
+		edits []lspTextEdit
+		want  string
+	}{
+		// Two imports added at the same place go in in array order.
+		{
+			"import (\n)\n",
+			[]lspTextEdit{insert(at(1, 0), "\t\"fmt\"\n"), insert(at(1, 0), "\t\"os\"\n")},
+			"import (\n\t\"fmt\"\n\t\"os\"\n)\n",
+		},
+		// Edits out of order, one replacing text and one after it.
+		{
+			"a := foo(foo)\n",
+			[]lspTextEdit{
+				{Range: lspRange{Start: at(0, 9), End: at(0, 12)}, NewText: "bar"},
+				{Range: lspRange{Start: at(0, 5), End: at(0, 8)}, NewText: "bar"},
+			},
+			"a := bar(bar)\n",
+		},
+		// An insert and a replacement starting at the same place.
+		{
+			"x := 1\n",
+			[]lspTextEdit{insert(at(0, 5), "-"), {Range: lspRange{Start: at(0, 5), End: at(0, 6)}, NewText: "2"}},
+			"x := -2\n",
+		},
+		// Columns count UTF-16 code units.
+		{
+			"s := \"é\" + y\n",
+			[]lspTextEdit{{Range: lspRange{Start: at(0, 11), End: at(0, 12)}, NewText: "z"}},
+			"s := \"é\" + z\n",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := applyTextEdits(tt.text, tt.edits); got != tt.want {
+			t.Errorf("applyTextEdits(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -4,24 +4,29 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 	"unicode"
 	"unsafe"
 )
 
 var errorlog *os.File
-var logger *log.Logger
+var logger = log.New(ioutil.Discard, "", 0)
 var prevCommand rune
 var prevCharacters []rune
 
 const (
-	TAB_STOP = 4
+	TAB_STOP    = 4
+	ESC_TIMEOUT = 25 * time.Millisecond
 )
 
 const (
@@ -37,6 +42,21 @@ const (
 	DEL_KEY      = 1008
 )
 
+const (
+	CTRL_C = 3
+	CTRL_D = 4
+	CTRL_E = 5
+	CTRL_F = 6
+	CTRL_I = 9
+	CTRL_N = 14
+	CTRL_O = 15
+	CTRL_P = 16
+	CTRL_R = 18
+	CTRL_U = 21
+	CTRL_X = 24
+	CTRL_Y = 25
+)
+
 const (
 	INSERT_MODE = 1
 	NORMAL_MODE = 2
@@ -66,9 +86,10 @@ const (
 )
 
 type hl_groups struct {
-	Comments   []string `json:"comments"`
-	Statements []string `josn:"statements"`
-	Types      []string `json:"types"`
+	Comments   []string       `json:"comments"`
+	Statements []string       `josn:"statements"`
+	Types      []string       `json:"types"`
+	Indent     *indentOptions `json:"indent"`
 }
 
 type winsize struct {
@@ -84,6 +105,7 @@ type erow struct {
 	size      int
 	rsize     int
 	highlight []int
+	marked    bool
 }
 
 type terminal int
@@ -103,9 +125,21 @@ type cursor struct {
 	x, y int
 }
 
+// searchObject is the last search: its pattern, direction and offset.
+// noSmartCase is set by * and #, which ignore the smartcase option. count is
+// the "[3/17]" shown in the status bar while the cursor stays at countAt.
 type searchObject struct {
-	query    string
-	location cursor
+	query       string
+	forward     bool
+	offset      searchOffset
+	noSmartCase bool
+	count       string
+	countAt     cursor
+}
+
+// matchRegion is a span of render columns on row y that is drawn highlighted.
+type matchRegion struct {
+	y, start, end int
 }
 
 type editorMsgBar struct {
@@ -114,12 +148,23 @@ type editorMsgBar struct {
 	fgColor int
 }
 
+type colorScheme struct {
+	text    int
+	number  int
+	lineNr  int
+	nonText int
+	search  int
+}
+
 type editor struct {
 	reader        terminal
 	orignial      syscall.Termios
 	height        int
 	width         int
 	editorUI      *bytes.Buffer
+	input         chan byte
+	pauseInput    chan bool
+	events        chan func()
 	cursor        cursor
 	mode          int
 	filename      string
@@ -132,6 +177,63 @@ type editor struct {
 	lineNumOffSet int
 	search        searchObject
 	modifiyed     bool
+	indent        indentOptions
+	number        bool
+	wrap          bool
+	linebreak     bool
+	showbreak     string
+	colors        colorScheme
+	syntaxDir     string
+	logFile       string
+	ignoreCase    bool
+	smartCase     bool
+	wrapScan      bool
+	incSearch     bool
+	hlSearch      bool
+	hlHidden      bool
+	marks         map[rune]cursor
+	undoStack     []undoState
+	redoStack     []undoState
+	undoLevels    int
+	undoDepth     int
+	undoSeq       int
+	lastSeq       int
+	savedSeq      int
+	executingKeys bool
+	lastSub       substitution
+	curMatch      *matchRegion
+	typeahead     []typedKey
+	timeout       bool
+	timeoutLen    int
+	mapLeader     string
+	shell         string
+	lastShell     string
+	grepPrg       string
+	grepFormat    string
+	errorFormat   string
+	errorFile     string
+	makePrg       string
+	making        bool
+	job           *exec.Cmd
+	formatOnSave  bool
+	importsOnSave bool
+	useLSP        bool
+	lspPrg        string
+	lsp           *lspServer
+	lspStarting   bool
+	jumps         []jump
+	jumpIdx       int
+	diagnostics   map[string][]lspDiagnostic
+	signs         []diagnostic
+	signWidth     int
+	diagMsg       string
+	virtualText   bool
+	quickfix      qfList
+	locList       qfList
+	refSearch     int
+	qfWin         qfWindow
+	outWin        outputPane
+	popup         popupMenu
 }
 
 func (r *erow) updateRow() {
@@ -139,16 +241,14 @@ func (r *erow) updateRow() {
 	buf := bytes.NewBufferString("")
 	raw := []byte(r.chars)
 	rsize := 0
-	for x := 0; x < len(raw); x++ {
+	for x := 0; x < r.size; x++ {
+		next := nextCol(raw[x], rsize)
 		if raw[x] == '\t' {
-			for i := 0; i < TAB_STOP; i++ {
-				rsize++
-				buf.WriteByte(' ')
-			}
+			buf.WriteString(strings.Repeat(" ", next-rsize))
 		} else {
-			rsize++
 			buf.WriteByte(raw[x])
 		}
+		rsize = next
 	}
 	buf.WriteByte('\000')
 	r.rsize = rsize
@@ -160,33 +260,32 @@ func (r *erow) updateSyntax() {
 	raw := []byte(r.render)
 	for x := 0; x < r.rsize; x++ {
 		if unicode.IsDigit(rune(raw[x])) && x != 0 && !unicode.IsLetter(rune(raw[x-1])) {
-			r.highlight = append(r.highlight, MAGENTA)
+			r.highlight = append(r.highlight, goedit.colors.number)
 		} else {
-			r.highlight = append(r.highlight, WHITE)
+			r.highlight = append(r.highlight, goedit.colors.text)
 		}
 	}
 }
 
-func (r *erow) deleteRune(pos int) {
-	if pos < 0 || pos > r.size {
-		return
-	}
+// text returns the row contents without the trailing NUL terminator.
+func (r *erow) text() string {
+	return r.chars[:r.size]
+}
 
-	buf := bytes.NewBufferString("")
-	raw := []byte(r.chars)
+// setChars replaces the row contents, keeping chars NUL terminated and size
+// in sync with it.
+func (r *erow) setChars(s string) {
+	r.chars = fmt.Sprintf("%s\000", strings.TrimRight(s, "\000"))
+	r.size = len(r.chars) - 1
+	r.updateRow()
+}
 
-	if pos == 0 {
-		buf.Write(raw[1:])
-	} else if pos == r.size {
-		buf.Write(raw[0 : len(raw)-2])
-	} else {
-		buf.Write(raw[0:pos])
-		buf.Write(raw[pos+1:])
+func (r *erow) deleteRune(pos int) {
+	if pos < 0 || pos >= r.size {
+		return
 	}
 
-	r.chars = buf.String()
-	r.size--
-	r.updateRow()
+	r.setChars(r.chars[:pos] + r.chars[pos+1:r.size])
 }
 
 func editorReplaceRune() {
@@ -202,13 +301,12 @@ func editorReplaceRune() {
 }
 
 func editorDelFromCursorToEndOfLine() {
-	raw := []byte(goedit.rows[goedit.cursor.y].chars)
-	buf := bytes.NewBuffer(raw[:goedit.cursor.x])
-	buf.WriteByte('\000')
+	if goedit.cursor.y >= goedit.numOfRows {
+		return
+	}
 
-	goedit.rows[goedit.cursor.y].chars = buf.String()
-	goedit.rows[goedit.cursor.y].size = len(buf.String())
-	goedit.rows[goedit.cursor.y].updateRow()
+	row := &goedit.rows[goedit.cursor.y]
+	row.setChars(row.text()[:goedit.cursor.x])
 	goedit.modifiyed = true
 }
 
@@ -244,9 +342,7 @@ func editorDelRow(pos int) {
 }
 
 func (r *erow) appendRow(chars string) {
-	r.chars = fmt.Sprintf("%s%s\000", r.chars, chars)
-	r.size = len(r.chars)
-	r.updateRow()
+	r.setChars(r.text() + strings.TrimRight(chars, "\000"))
 }
 
 func (r *erow) insertRune(c rune, pos int) {
@@ -254,24 +350,10 @@ func (r *erow) insertRune(c rune, pos int) {
 		pos = r.size
 	}
 
-	buf := bytes.NewBufferString("")
-	raw := []byte(r.chars)
-
-	if pos == 0 {
-		buf.WriteRune(c)
-		buf.WriteString(r.chars)
-	} else if pos == r.size {
-		buf.WriteString(r.chars)
-		buf.WriteRune(c)
-	} else {
-		buf.WriteString(string(raw[0:pos]))
-		buf.WriteRune(c)
-		buf.WriteString(string(raw[pos:]))
-	}
-
-	r.chars = buf.String()
-	r.size = len(r.chars)
-	r.updateRow()
+	buf := bytes.NewBufferString(r.chars[:pos])
+	buf.WriteRune(c)
+	buf.WriteString(r.chars[pos:r.size])
+	r.setChars(buf.String())
 }
 
 func editorInsertRune(c rune) {
@@ -289,47 +371,67 @@ func (e *editor) insertRow(pos int, r string) {
 		return
 	}
 
-	buf := bytes.NewBufferString(strings.TrimRight(r, "\000"))
-	buf.WriteByte('\000')
-	row := erow{chars: buf.String()}
-	row.size = len(row.chars) - 1
-
-	row.updateRow()
+	row := erow{}
+	row.setChars(r)
 
-	if pos == 0 {
-		var rows []erow
-		rows = append(rows, row)
-		goedit.rows = append(rows, goedit.rows...)
-	} else if pos == goedit.numOfRows {
-		goedit.rows = append(goedit.rows, row)
-	} else {
-		rows := append(goedit.rows[:pos-1], row)
-		goedit.rows = append(rows, goedit.rows[pos-1:]...)
-	}
+	goedit.rows = append(goedit.rows, erow{})
+	copy(goedit.rows[pos+1:], goedit.rows[pos:])
+	goedit.rows[pos] = row
 
 	goedit.numOfRows++
-	e.lineNumOffSet = int(math.Log10(float64(e.numOfRows))) + 2
+	e.updateGutter()
+}
+
+// updateGutter sizes the line number column for the current number of rows,
+// or hides it when the number option is off, and adds the sign column.
+func (e *editor) updateGutter() {
+	e.lineNumOffSet = 0
+	if e.number && e.numOfRows > 0 {
+		e.lineNumOffSet = int(math.Log10(float64(e.numOfRows))) + 2
+	}
+	e.lineNumOffSet += e.signWidth
 }
 
 func editorInsertNewline() {
-	if goedit.cursor.x == 0 {
-		goedit.insertRow(goedit.cursor.y, "")
-	} else {
-		raw := []byte(goedit.rows[goedit.cursor.y].chars)
-		newline := string(raw[goedit.cursor.x:])
-		oldline := fmt.Sprintf("%s\000", string(raw[:goedit.cursor.x]))
-		goedit.insertRow(goedit.cursor.y+1, newline)
-		goedit.rows[goedit.cursor.y].chars = oldline
-		goedit.rows[goedit.cursor.y].size = len(oldline)
-		goedit.rows[goedit.cursor.y].updateRow()
+	if goedit.cursor.y == goedit.numOfRows {
+		goedit.insertRow(goedit.numOfRows, "")
 	}
 
-	goedit.cursor.x = 0
+	text := goedit.rows[goedit.cursor.y].text()
+	before := text[:goedit.cursor.x]
+	after := text[goedit.cursor.x:]
+
+	indent := ""
+	if goedit.indent.AutoIndent && goedit.cursor.x > 0 {
+		indent = leadingWhitespace(before)
+		after = strings.TrimLeft(after, " \t")
+		if goedit.indent.SmartIndent && opensBlock(before) {
+			closing := indent
+			indent += goedit.indent.unit()
+			if strings.HasPrefix(after, "}") || strings.HasPrefix(after, ")") {
+				goedit.insertRow(goedit.cursor.y+1, closing+after)
+				after = ""
+			}
+		}
+	}
+
+	goedit.rows[goedit.cursor.y].setChars(before)
+	goedit.insertRow(goedit.cursor.y+1, indent+after)
+
+	goedit.cursor.x = len(indent)
 	goedit.cursor.y++
 	goedit.modifiyed = true
 }
 
 func editorPrompt(msg string) string {
+	s, _ := editorReadPrompt(msg, nil)
+	return s
+}
+
+// editorReadPrompt reads a line after msg in the message bar, calling
+// onChange, when not nil, with the text each time it changes. It reports
+// false when Escape cancelled it.
+func editorReadPrompt(msg string, onChange func(string)) (string, bool) {
 	oldcursor := goedit.cursor
 	buf := bytes.NewBufferString("")
 	msgLength := len(msg)
@@ -339,7 +441,7 @@ func editorPrompt(msg string) string {
 
 	for {
 		goedit.editormsg.msg = fmt.Sprintf("%s%s", msg, buf)
-		goedit.cursor.y = goedit.height + 1
+		goedit.cursor.y = goedit.messageRow()
 		clearScreen()
 
 		key := readKey()
@@ -347,7 +449,11 @@ func editorPrompt(msg string) string {
 		case '\r':
 			//goedit.editormsg = ""
 			goedit.cursor = oldcursor
-			return buf.String()
+			return buf.String(), true
+		case '\x1b':
+			goedit.cursor = oldcursor
+			editorMessage("")
+			return "", false
 		case BACKSPACE:
 			if goedit.cursor.x <= msgLength {
 				break
@@ -365,6 +471,9 @@ func editorPrompt(msg string) string {
 			}
 
 			goedit.cursor.x--
+			if onChange != nil {
+				onChange(buf.String())
+			}
 		case CURSOR_LEFT:
 			if goedit.cursor.x != msgLength {
 				goedit.cursor.x--
@@ -376,18 +485,27 @@ func editorPrompt(msg string) string {
 		default:
 			buf.WriteRune(key)
 			goedit.cursor.x++
+			if onChange != nil {
+				onChange(buf.String())
+			}
 		}
 	}
 }
 
+// nextCol returns the render column following the byte c drawn at col. It is
+// the single place tabs are expanded, so rendering and cursor math agree.
+func nextCol(c byte, col int) int {
+	if c == '\t' {
+		return col + goedit.indent.TabStop - col%goedit.indent.TabStop
+	}
+
+	return col + 1
+}
+
 func cursorxToRx(row erow, cx int) int {
 	rx := 0
-	raw := []byte(row.chars)
-	for x := 0; x < cx; x++ {
-		if raw[x] == '\t' {
-			rx += (TAB_STOP - 1) - (rx % TAB_STOP)
-		}
-		rx++
+	for x := 0; x < cx && x < row.size; x++ {
+		rx = nextCol(row.chars[x], rx)
 	}
 
 	return rx
@@ -397,10 +515,7 @@ func cursorxToCx(row erow, rx int) int {
 	cur_rx := 0
 	cx := 0
 	for cx = 0; cx < row.size; cx++ {
-		if row.chars[cx] == '\t' {
-			cur_rx += (TAB_STOP - 1) - (cur_rx % TAB_STOP)
-		}
-		cur_rx++
+		cur_rx = nextCol(row.chars[cx], cur_rx)
 
 		if cur_rx > rx {
 			return cx
@@ -413,16 +528,23 @@ func cursorxToCx(row erow, rx int) int {
 var goedit editor
 
 func init() {
-	errorlog, errr := os.OpenFile("log.txt", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-	if errr != nil {
-		logger.Fatal(errr)
-	}
-
-	logger = log.New(errorlog, "goedit: ", log.Lshortfile|log.LstdFlags)
-
 	goedit = editor{}
 	goedit.mode = NORMAL_MODE
+	goedit.input = make(chan byte, 64)
+	goedit.pauseInput = make(chan bool)
+	goedit.events = make(chan func(), 16)
+	goedit.diagnostics = map[string][]lspDiagnostic{}
+	goedit.marks = map[rune]cursor{}
+	initOptions()
 
+	goedit.editorUI = bytes.NewBufferString("")
+	goedit.editormsg.fgColor = WHITE
+	goedit.editormsg.bgColor = 49
+}
+
+// initTerminal reads the terminal's settings and size. It is left out of
+// init so the package can be loaded without a terminal, as go test does.
+func initTerminal() {
 	goedit.reader = terminal(syscall.Stdin)
 	if err := goedit.getShellNormal(); err != 0 {
 		logger.Fatal(err)
@@ -434,21 +556,36 @@ func init() {
 	}
 	goedit.height = int(winsize.height) - 2
 	goedit.width = int(winsize.width)
-
-	goedit.editorUI = bytes.NewBufferString("")
-	goedit.editormsg.fgColor = WHITE
-	goedit.editormsg.bgColor = 49
 }
 
-func openFile(filename string) {
+// openFile replaces the buffer with filename, or with an empty buffer that
+// will be written to filename when it does not exist yet.
+func openFile(filename string) error {
 	file, err := os.Open(filename)
-	if err != nil {
-		logger.Fatal(err)
+	if err != nil && !os.IsNotExist(err) {
+		return exErr(484, "Can't open file", filename)
+	}
+
+	lspCloseDocument()
+	defer lspOpenDocument()
+
+	goedit.rows = []erow{}
+	goedit.numOfRows = 0
+	goedit.cursor = cursor{}
+	goedit.rowOffSet, goedit.colOffSet = 0, 0
+	goedit.marks = map[rune]cursor{}
+	goedit.undoStack, goedit.redoStack = nil, nil
+	markSaved()
+	goedit.filename = filename
+	selectSyntax(filename)
+
+	if file == nil {
+		editorMessage(fmt.Sprintf("\"%s\" [New]", filename))
+		return nil
 	}
 	defer file.Close()
 
 	line := 0
-	goedit.rows = []erow{}
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		goedit.insertRow(line, scanner.Text())
@@ -456,11 +593,12 @@ func openFile(filename string) {
 	}
 
 	goedit.numOfRows = len(goedit.rows)
-	goedit.filename = filename
-	selectSyntax(filename)
+	return nil
 }
 
 func selectSyntax(filename string) {
+	resetLocalOptions(SCOPE_BUFFER)
+
 	var syntaxFile string
 	switch filepath.Ext(filename) {
 	case ".go":
@@ -469,25 +607,40 @@ func selectSyntax(filename string) {
 		return
 	}
 
-	syntax, errr := os.Open(syntaxFile)
+	syntax, errr := os.Open(filepath.Join(goedit.syntaxDir, syntaxFile))
 	if errr != nil {
 		logger.Println("no syntax file found")
 		return
 	}
 	defer syntax.Close()
 
-	var syn hl_groups
+	indent := goedit.indent
+	syn := hl_groups{Indent: &indent}
 	decoder := json.NewDecoder(syntax)
 	if errr := decoder.Decode(&syn); errr != nil {
 		logger.Println(errr)
 		return
 	}
+
+	if indent.TabStop > 0 {
+		goedit.indent = indent
+	}
+
+	// Options set in the config or with :set win over the syntax file.
+	for _, o := range options {
+		if o.scope == SCOPE_BUFFER && o.userSet {
+			o.store(o.global)
+		}
+	}
 }
 
 func drawStatusBar() {
 	status := fmt.Sprintf("%.20s - %d lines", goedit.filename, goedit.numOfRows)
 	length := len(status)
 	rstatus := fmt.Sprintf("%d,%d", goedit.cursor.y+1, goedit.rx+1)
+	if goedit.search.count != "" && goedit.search.countAt == goedit.cursor && goedit.mode != CMD_MODE {
+		rstatus = goedit.search.count + "  " + rstatus
+	}
 	rlength := len(rstatus)
 	goedit.editorUI.WriteString("\x1b[7m")
 	goedit.editorUI.WriteString(status)
@@ -512,28 +665,79 @@ func drawMessageBar() {
 }
 
 func drawRows() {
+	filerow := goedit.rowOffSet
+	seg := 0
+	re := highlightRegexp()
+	signs := rowSigns()
 	for x := 0; x < goedit.height; x++ {
-		filerow := x + goedit.rowOffSet
 		if filerow >= goedit.numOfRows {
 			goedit.editorUI.WriteString("~")
 		} else {
-			length := goedit.rows[filerow].rsize - goedit.colOffSet
-			if length < 0 {
-				length = 0
+			row := goedit.rows[filerow]
+			start := goedit.colOffSet
+			end := start + goedit.textWidth()
+			segs := []int{0}
+			if goedit.wrap {
+				segs = goedit.wrapRow(row)
+				start = segs[seg]
+				end = row.rsize
+				if seg+1 < len(segs) {
+					end = segs[seg+1]
+				}
+			}
+
+			if end > row.rsize {
+				end = row.rsize
 			}
 
-			if length > goedit.width {
-				length = goedit.width
+			if seg == 0 && goedit.signWidth > 0 {
+				drawSign(signs[filerow])
+			}
+
+			if seg == 0 && goedit.lineNumOffSet > goedit.signWidth {
+				formatter := fmt.Sprintf("%%%dd ", goedit.lineNumOffSet-goedit.signWidth-1)
+				goedit.editorUI.WriteString(fmt.Sprintf("\x1b[%dm", goedit.colors.lineNr))
+				goedit.editorUI.WriteString(fmt.Sprintf(formatter, filerow+1))
+				goedit.editorUI.WriteString("\x1b[39;49m")
+			} else if seg > 0 {
+				goedit.editorUI.WriteString(strings.Repeat(" ", goedit.lineNumOffSet))
+				goedit.editorUI.WriteString(fmt.Sprintf("\x1b[%dm", goedit.colors.nonText))
+				goedit.editorUI.WriteString(goedit.showbreak)
+				goedit.editorUI.WriteString("\x1b[39;49m")
+			}
+
+			matches := rowMatches(re, row)
+			underline := diagnosticRegions(row, signs[filerow])
+			text := []byte(row.render)
+			for i := start; i < end; i++ {
+				goedit.editorUI.WriteString(fmt.Sprintf("\x1b[%dm", row.highlight[i]))
+				if inMatch(underline, i) {
+					goedit.editorUI.WriteString("\x1b[4m")
+				}
+				if m := goedit.curMatch; m != nil && m.y == filerow && i >= m.start && i < m.end {
+					goedit.editorUI.WriteString("\x1b[7m")
+					goedit.editorUI.WriteByte(text[i])
+					goedit.editorUI.WriteString("\x1b[27m")
+				} else if inMatch(matches, i) {
+					goedit.editorUI.WriteString(fmt.Sprintf("\x1b[%d;%dm", BLACK, goedit.colors.search+10))
+					goedit.editorUI.WriteByte(text[i])
+					goedit.editorUI.WriteString("\x1b[49m")
+				} else {
+					goedit.editorUI.WriteByte(text[i])
+				}
+				if inMatch(underline, i) {
+					goedit.editorUI.WriteString("\x1b[24m")
+				}
 			}
 
-			text := []byte(goedit.rows[filerow].render)
-			formatter := fmt.Sprintf("%%%dd ", goedit.lineNumOffSet-1)
-			goedit.editorUI.WriteString(fmt.Sprintf("\x1b[%dm", GREEN))
-			goedit.editorUI.WriteString(fmt.Sprintf(formatter, filerow+1))
-			goedit.editorUI.WriteString("\x1b[39;49m")
-			for i := goedit.colOffSet; i < length; i++ {
-				goedit.editorUI.WriteString(fmt.Sprintf("\x1b[%dm", goedit.rows[filerow].highlight[i]))
-				goedit.editorUI.WriteByte(text[i])
+			if goedit.virtualText && seg+1 >= len(segs) && end >= start {
+				drawVirtualText(signs[filerow], goedit.textWidth()-(end-start))
+			}
+
+			seg++
+			if seg >= len(segs) {
+				filerow++
+				seg = 0
 			}
 		}
 
@@ -553,6 +757,14 @@ func scroll() {
 			goedit.rowOffSet = goedit.cursor.y
 		}
 
+		if goedit.wrap {
+			goedit.colOffSet = 0
+			for goedit.rowOffSet < goedit.cursor.y && goedit.cursorScreenRow() >= goedit.height {
+				goedit.rowOffSet++
+			}
+			return
+		}
+
 		if goedit.cursor.y >= goedit.rowOffSet+goedit.height {
 			goedit.rowOffSet = goedit.cursor.y - goedit.height + 1
 		}
@@ -561,8 +773,8 @@ func scroll() {
 			goedit.colOffSet = goedit.rx
 		}
 
-		if goedit.rx >= goedit.colOffSet+goedit.width {
-			goedit.colOffSet = goedit.rx - goedit.width + 1
+		if goedit.rx >= goedit.colOffSet+goedit.textWidth() {
+			goedit.colOffSet = goedit.rx - goedit.textWidth() + 1
 		}
 	}
 }
@@ -573,94 +785,138 @@ func rawMode() {
 	argp.Oflag &^= syscall.OPOST
 	argp.Cflag |= syscall.CS8
 	argp.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.ISIG
-	argp.Cc[syscall.VMIN] = 1
-	argp.Cc[syscall.VTIME] = 0
+	// Reads return after a tenth of a second without input, so readInput
+	// can be paused between them.
+	argp.Cc[syscall.VMIN] = 0
+	argp.Cc[syscall.VTIME] = 1
 
 	if err := goedit.rawMode(argp); err != 0 {
 		logger.Fatal(err)
 	}
 }
 
-func readKey() rune {
+// readInput copies bytes from the terminal to e.input so keys can be read with
+// a timeout. A value on e.pauseInput stops it reading until the next one.
+func (e *editor) readInput() {
 	var buf [1]byte
 
 	for {
-		n, err := goedit.reader.Read(buf[:])
+		select {
+		case <-e.pauseInput:
+			<-e.pauseInput
+			continue
+		default:
+		}
+
+		n, err := e.reader.Read(buf[:])
 		if err != nil {
 			logger.Fatal(err)
 		}
 
 		if n == 1 {
-			break
+			e.input <- buf[0]
 		}
 	}
+}
 
-	if buf[0] == '\x1b' {
-		var seq [2]byte
-		n, err := goedit.reader.Read(seq[:])
-		if err != nil {
-			logger.Fatal(err)
+// readByte waits up to timeout for a byte of input, or forever when timeout
+// is negative. In normal mode it meanwhile runs the events that background
+// jobs such as :make send, redrawing the screen after each.
+func readByte(timeout time.Duration) (byte, bool) {
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		expired = time.After(timeout)
+	}
+
+	for {
+		var events chan func()
+		if goedit.mode == NORMAL_MODE && !goedit.executingKeys {
+			events = goedit.events
 		}
 
-		if n != 2 {
-			return '\x1b'
+		select {
+		case b := <-goedit.input:
+			return b, true
+		case event := <-events:
+			event()
+			clearScreen()
+		case <-expired:
+			return 0, false
+		}
+	}
+}
+
+func readKey() rune {
+	key, _ := nextKey(-1)
+	return key.key
+}
+
+// readTerminalKey reads one key from the terminal, decoding escape sequences.
+// A lone escape is told apart from a sequence by how quickly the rest follows.
+func readTerminalKey(timeout time.Duration) (rune, bool) {
+	b, ok := readByte(timeout)
+	if !ok {
+		return 0, false
+	}
+
+	if b == '\x1b' {
+		var seq [2]byte
+		for i := range seq {
+			if seq[i], ok = readByte(ESC_TIMEOUT); !ok {
+				return '\x1b', true
+			}
 		}
 
 		if seq[0] == '[' {
 			if seq[1] >= '0' && seq[1] <= '9' {
-				var tilde [1]byte
-				n, err := goedit.reader.Read(tilde[:])
-				if err != nil {
-					logger.Fatal(err)
+				tilde, ok := readByte(ESC_TIMEOUT)
+				if !ok {
+					return '\x1b', true
 				}
 
-				if n != 1 {
-					return '\x1b'
-				}
-
-				if tilde[0] == '~' {
+				if tilde == '~' {
 					switch seq[1] {
 					case '1', '7':
-						return HOME_KEY
+						return HOME_KEY, true
 					case '3':
-						return DEL_KEY
+						return DEL_KEY, true
 					case '4', '8':
-						return END_KEY
+						return END_KEY, true
 					case '5':
-						return PAGE_UP
+						return PAGE_UP, true
 					case '6':
-						return PAGE_DOWN
+						return PAGE_DOWN, true
 					}
 				}
 			} else {
 				switch seq[1] {
 				case 'A':
-					return CURSOR_UP
+					return CURSOR_UP, true
 				case 'B':
-					return CURSOR_DOWN
+					return CURSOR_DOWN, true
 				case 'C':
-					return CURSOR_RIGHT
+					return CURSOR_RIGHT, true
 				case 'D':
-					return CURSOR_LEFT
+					return CURSOR_LEFT, true
 				case 'H':
-					return HOME_KEY
+					return HOME_KEY, true
 				case 'F':
-					return END_KEY
+					return END_KEY, true
 				}
 			}
 		} else if seq[0] == 'O' {
 			switch seq[1] {
 			case 'H':
-				return HOME_KEY
+				return HOME_KEY, true
 			case 'F':
-				return END_KEY
+				return END_KEY, true
 			}
 		}
 
-		return '\x1b'
+		return '\x1b', true
 	}
 
-	return bytes.Runes(buf[:])[0]
+	return rune(b), true
 }
 
 func (e *editor) moveCursor(key rune) {
@@ -707,46 +963,64 @@ func (e *editor) rowsToString() string {
 	return buf.String()
 }
 
-func (e *editor) save() {
+func (e *editor) save() error {
 	if e.filename == "" {
 		e.filename = editorPrompt("Save as ")
+		if e.filename == "" {
+			return exErr(32, "No file name", "")
+		}
 		selectSyntax(e.filename)
+		e.updateAllRows()
 	}
 
-	file, err := os.OpenFile(e.filename, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		logger.Fatal(err)
-	}
-	defer file.Close()
-
-	text := e.rowsToString()
-	length := len(text)
-
-	if err := file.Truncate(int64(length)); err != nil {
-		e.editormsg.msg = err.Error()
-		return
+	if strings.HasSuffix(e.filename, ".go") && hasPackageClause(e.rowsToString()) {
+		if e.importsOnSave {
+			if err := importsBuffer(); err != nil {
+				return err
+			}
+		} else if e.formatOnSave {
+			if err := formatBuffer(); err != nil {
+				return err
+			}
+		}
 	}
 
-	n, err := file.WriteAt([]byte(text), 0)
+	n, err := writeText(e.filename, e.rowsToString(), false)
 	if err != nil {
-		e.editormsg.msg = err.Error()
-		return
+		return err
 	}
 
-	e.editormsg.msg = fmt.Sprintf("\"%s\" %dL %d bytess written to disk", e.filename, e.numOfRows, n)
-	goedit.modifiyed = false
+	e.editormsg.msg = fmt.Sprintf("\"%s\" %dL %d bytes written to disk", e.filename, e.numOfRows, n)
+	markSaved()
+	lspDidSave()
+
+	return nil
 }
 
 func clearScreen() {
+	updateDiagnostics()
 	scroll()
 	goedit.editorUI.Reset()
 	goedit.editorUI.WriteString("\x1b[?25l")
 	goedit.editorUI.WriteString("\x1b[H")
 	drawRows()
 	drawStatusBar()
+	if goedit.qfWin.open {
+		drawQuickfix()
+	}
+	if goedit.outWin.open {
+		drawOutputPane()
+	}
 	drawMessageBar()
-	if goedit.mode == CMD_MODE {
+	if goedit.popup.open {
+		drawPopup()
+	}
+	if goedit.qfWin.focus {
+		goedit.editorUI.WriteString(fmt.Sprintf("\x1b[%d;1H", goedit.height+2+goedit.qfWin.sel-goedit.qfWin.top))
+	} else if goedit.mode == CMD_MODE {
 		goedit.editorUI.WriteString(fmt.Sprintf("\x1b[%d;%dH", goedit.cursor.y+1, goedit.cursor.x+1))
+	} else if goedit.wrap {
+		goedit.editorUI.WriteString(fmt.Sprintf("\x1b[%d;%dH", goedit.cursorScreenRow()+1, goedit.cursorScreenCol()+1+goedit.lineNumOffSet))
 	} else {
 		goedit.editorUI.WriteString(fmt.Sprintf("\x1b[%d;%dH", (goedit.cursor.y-goedit.rowOffSet)+1, (goedit.rx-goedit.colOffSet)+1+goedit.lineNumOffSet))
 	}
@@ -756,91 +1030,6 @@ func clearScreen() {
 	goedit.editorUI.Reset()
 }
 
-func editorSearch() {
-	query := editorPrompt("/")
-	for i, row := range goedit.rows {
-		indx := strings.Index(row.render, query)
-		if indx != -1 {
-			goedit.cursor.y = i
-			goedit.cursor.x = cursorxToCx(row, indx)
-			goedit.search.location = goedit.cursor
-			goedit.search.query = query
-			return
-		}
-	}
-
-	goedit.editormsg.msg = fmt.Sprintf("Pattern not found: %s", query)
-	goedit.editormsg.fgColor = WHITE
-	goedit.editormsg.bgColor = BLUE + 10
-}
-
-func editorNextSearch() {
-	if goedit.search.query == "" {
-		return
-	}
-
-	if goedit.search.location.x+1 < goedit.rows[goedit.search.location.y].rsize {
-		raw := []byte(goedit.rows[goedit.search.location.y].render)
-		loc := cursorxToRx(goedit.rows[goedit.search.location.y], goedit.search.location.x+1)
-		indx := strings.Index(string(raw[loc:]), goedit.search.query)
-		if indx != -1 {
-			goedit.cursor.x = cursorxToCx(goedit.rows[goedit.search.location.y], indx)
-			goedit.search.location.x = loc
-			return
-		}
-	}
-
-	for i := goedit.search.location.y + 1; i < goedit.numOfRows; i++ {
-		indx := strings.Index(goedit.rows[i].render, goedit.search.query)
-		if indx != -1 {
-			goedit.cursor.y = i
-			goedit.cursor.x = cursorxToCx(goedit.rows[i], indx)
-			goedit.search.location.y = i
-			goedit.search.location.x = indx
-			return
-		}
-	}
-
-	goedit.editormsg.msg = "Hit bottom, starting from the top"
-	goedit.editormsg.fgColor = RED
-	goedit.search.location.x = 0
-	goedit.search.location.y = 0
-	editorNextSearch()
-}
-
-func editorPrevSearch() {
-	if goedit.search.query == "" {
-		return
-	}
-
-	if goedit.search.location.x-1 > 0 {
-		raw := []byte(goedit.rows[goedit.search.location.y].render)
-		indx := strings.Index(string(raw[:goedit.search.location.x-1]), goedit.search.query)
-		if indx != -1 {
-			goedit.cursor.x = cursorxToCx(goedit.rows[goedit.search.location.y], indx)
-			goedit.search.location.x = indx
-			return
-		}
-	}
-
-	for i := goedit.search.location.y - 1; i > 0; i-- {
-		indx := strings.Index(goedit.rows[i].render, goedit.search.query)
-		if indx != -1 {
-			goedit.cursor.y = i
-			goedit.cursor.x = cursorxToCx(goedit.rows[i], indx)
-			goedit.search.location.y = i
-			goedit.search.location.x = indx
-			return
-		}
-	}
-
-	goedit.editormsg.msg = "Hit top, starting from the bottom"
-	goedit.editormsg.fgColor = RED
-	goedit.search.location.x = goedit.rows[goedit.numOfRows-1].rsize
-	goedit.search.location.y = goedit.numOfRows - 1
-	editorPrevSearch()
-}
-
 func editorQuit(force bool) {
 	if goedit.modifiyed == true && force == false {
 		goedit.editormsg.msg = "No write since last change (add ! to override)"
@@ -849,6 +1038,7 @@ func editorQuit(force bool) {
 		return
 	}
 
+	stopLSP()
 	goedit.resetMode()
 	os.Exit(0)
 }
@@ -877,29 +1067,53 @@ func editorFindInRow(direction int, modifier int) {
 	goedit.cursor.x = indx + modifier + cursorx
 }
 
+// editorMessage shows msg in the message bar.
+func editorMessage(msg string) {
+	goedit.editormsg.msg = msg
+	goedit.editormsg.fgColor = WHITE
+	goedit.editormsg.bgColor = 49
+}
+
+// editorShowLines shows output too long for the message bar over the whole
+// screen until a key is pressed.
+func editorShowLines(lines []string) {
+	goedit.editorUI.Reset()
+	goedit.editorUI.WriteString("\x1b[H\x1b[2J")
+	for _, line := range lines {
+		goedit.editorUI.WriteString(line)
+		goedit.editorUI.WriteString("\r\n")
+	}
+	goedit.reader.Write(goedit.editorUI.String())
+	goedit.editorUI.Reset()
+
+	editorHitEnter()
+}
+
+// editorHitEnter waits for a key after output written over the screen.
+func editorHitEnter() {
+	goedit.reader.Write("Press ENTER or type command to continue")
+	readKey()
+}
+
+// editorError shows err in the message bar.
+func editorError(err error) {
+	goedit.editormsg.msg = err.Error()
+	goedit.editormsg.fgColor = WHITE
+	goedit.editormsg.bgColor = BLUE + 10
+}
+
 func editorCommandMode() {
-	result := editorPrompt(":")
-	cmd := strings.Split(result, " ")
-
-	switch cmd[0] {
-	case "q", "quit":
-		editorQuit(false)
-	case "q!", "quit!":
-		editorQuit(true)
-	case "w", "write":
-		goedit.save()
-	case "wq":
-		goedit.save()
-		goedit.resetMode()
-		os.Exit(0)
-	case "o", "open":
-		if len(cmd) == 2 {
-			openFile(cmd[1])
-		}
+	if err := executeCommand(editorPrompt(":")); err != nil {
+		editorError(err)
 	}
 }
 
 func getNormalModeCommand(key rune, clear bool) bool {
+	switch key {
+	case 'i', 'a', 'C', 'D', 'O', 's', 'r', 'x':
+		saveUndo()
+	}
+
 	switch key {
 	case 'h':
 		goedit.moveCursor(CURSOR_LEFT)
@@ -913,10 +1127,16 @@ func getNormalModeCommand(key rune, clear bool) bool {
 		goedit.mode = CMD_MODE
 		editorCommandMode()
 		goedit.mode = NORMAL_MODE
-	case '/':
+	case '/', '?':
 		goedit.mode = CMD_MODE
-		editorSearch()
+		editorSearchPrompt(key == '/')
 		goedit.mode = NORMAL_MODE
+	case '*', '#':
+		editorSearchWord(key == '*')
+	case 'K':
+		if err := editorHover(); err != nil {
+			editorError(err)
+		}
 	case 'i':
 		if clear {
 			prevCharacters = prevCharacters[:0]
@@ -939,6 +1159,53 @@ func getNormalModeCommand(key rune, clear bool) bool {
 		}
 	case '0':
 		goedit.cursor.x = 0
+	case 'u':
+		editorUndo()
+	case CTRL_R:
+		editorRedo()
+	case 'm':
+		if mark := readKey(); mark >= 'a' && mark <= 'z' {
+			goedit.marks[mark] = goedit.cursor
+		}
+	case '\'', '`':
+		editorJumpToMark(readKey(), key == '\'')
+	case '!':
+		goedit.mode = CMD_MODE
+		editorFilterMotion()
+		goedit.mode = NORMAL_MODE
+	case ']', '[':
+		if readKey() == 'd' {
+			dir := 1
+			if key == '[' {
+				dir = -1
+			}
+			if err := editorJumpDiagnostic(dir); err != nil {
+				editorError(err)
+			}
+		}
+	case CTRL_O, CTRL_I:
+		if err := editorJump(key == CTRL_O); err != nil {
+			editorError(err)
+		}
+	case CTRL_C:
+		if goedit.job != nil {
+			stopGoJob()
+		}
+	case 'g':
+		switch readKey() {
+		case 'j':
+			editorMoveScreenLine(CURSOR_DOWN)
+		case 'k':
+			editorMoveScreenLine(CURSOR_UP)
+		case 'd':
+			if err := editorGotoDefinition(); err != nil {
+				editorError(err)
+			}
+		case 'r':
+			if err := editorReferences(); err != nil {
+				editorError(err)
+			}
+		}
 	case 'D':
 		editorDelFromCursorToEndOfLine()
 		goedit.moveCursor(CURSOR_LEFT)
@@ -1002,7 +1269,7 @@ func getNormalModeCommand(key rune, clear bool) bool {
 				getNormalModeCommand(prevCommand, false)
 
 				for _, char := range characters {
-					editorInsertRune(char)
+					editorInsertKey(char)
 				}
 			}
 		default:
@@ -1015,11 +1282,16 @@ func getNormalModeCommand(key rune, clear bool) bool {
 		goedit.editormsg.bgColor = 49
 	}
 
+	switch key {
+	case 'D', 'r', 'x':
+		dropEmptyUndo()
+	}
+
 	return true
 }
 
 func processKeyPress() {
-	key := readKey()
+	key := readMappedKey(goedit.mode)
 	com := true
 
 	if goedit.mode == NORMAL_MODE {
@@ -1031,6 +1303,9 @@ func processKeyPress() {
 		case CURSOR_DOWN, CURSOR_UP, CURSOR_LEFT, CURSOR_RIGHT:
 			goedit.moveCursor(key)
 		case '\x1b':
+			if goedit.mode == INSERT_MODE {
+				dropEmptyUndo()
+			}
 			goedit.mode = NORMAL_MODE
 			goedit.editormsg.msg = ""
 			goedit.editormsg.fgColor = WHITE
@@ -1058,12 +1333,27 @@ func processKeyPress() {
 			if goedit.mode == NORMAL_MODE {
 				return
 			}
-			editorDelRune()
+			editorDelSoftTab()
 		case DEL_KEY:
 			if key == DEL_KEY {
 				goedit.moveCursor(CURSOR_RIGHT)
 			}
 			editorDelRune()
+		case CTRL_N, CTRL_P:
+			if goedit.mode == INSERT_MODE {
+				editorComplete(COMPLETE_KEYWORD, key)
+			}
+		case CTRL_X:
+			if goedit.mode == INSERT_MODE {
+				switch next := readKey(); next {
+				case CTRL_N, CTRL_P:
+					editorComplete(COMPLETE_KEYWORD, next)
+				case CTRL_F:
+					editorComplete(COMPLETE_FILE, next)
+				case CTRL_O:
+					editorComplete(COMPLETE_OMNI, next)
+				}
+			}
 		case '\r':
 			if goedit.mode == INSERT_MODE {
 				editorInsertNewline()
@@ -1072,7 +1362,7 @@ func processKeyPress() {
 			}
 		default:
 			if goedit.mode == INSERT_MODE {
-				editorInsertRune(key)
+				editorInsertKey(key)
 				prevCharacters = append(prevCharacters, key)
 			}
 		}
@@ -1080,12 +1370,27 @@ func processKeyPress() {
 }
 
 func main() {
+	configFile := flag.String("u", "", "use this config file instead of the default, NONE to skip it")
+	flag.Parse()
+
+	initTerminal()
 	rawMode()
-	if len(os.Args) == 2 {
-		openFile(os.Args[1])
+	go goedit.readInput()
+	configErr := loadConfig(*configFile)
+	if flag.NArg() == 1 {
+		if err := openFile(flag.Arg(0)); err != nil {
+			editorError(err)
+		}
+	}
+	if configErr != nil {
+		editorError(configErr)
 	}
 
 	for {
+		lspReadInbox()
+		lspSync()
+		updateDiagnostics()
+		showCursorDiagnostic()
 		clearScreen()
 		processKeyPress()
 	}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// exMake implements :mak[e][!] [args], running the makeprg option with args
+// in place of $*, or after it, in the background. Its output goes into the
+// quickfix list once it is done, and without ! the cursor jumps to the first
+// error.
+func exMake(c *exCmd) error {
+	cmd := goedit.makePrg
+	if strings.Contains(cmd, "$*") {
+		cmd = strings.Replace(cmd, "$*", c.arg, -1)
+	} else if c.arg != "" {
+		cmd += " " + c.arg
+	}
+
+	return startMake(":make "+c.arg, cmd, !c.bang)
+}
+
+// exGoBuild implements :GoBuild[!] [args], building the package of the
+// current file, or the packages given, into the quickfix list as :make does.
+func exGoBuild(c *exCmd) error {
+	args := c.arg
+	if args == "" {
+		args = "./..."
+		if goedit.filename != "" {
+			args = goPackageDir(goedit.filename)
+		}
+	}
+
+	return startMake(":GoBuild "+c.arg, "go build "+args, !c.bang)
+}
+
+// goPackageDir returns the directory of filename in the form the go command
+// takes as a package path, such as ./sub.
+func goPackageDir(filename string) string {
+	dir := filepath.Dir(filename)
+	if filepath.IsAbs(dir) || dir == "." {
+		return dir
+	}
+
+	return "." + string(filepath.Separator) + dir
+}
+
+// startMake writes the buffer when it is modified and runs cmd in the
+// background, so editing goes on while it builds. When it finishes its
+// output is parsed with the errorformat option into the quickfix list.
+func startMake(title string, cmd string, jump bool) error {
+	if goedit.making {
+		return exErr(948, "Job still running", "")
+	}
+
+	cmd, err := expandFileName(cmd)
+	if err != nil {
+		return err
+	}
+
+	if goedit.modifiyed && goedit.filename != "" {
+		if err := goedit.save(); err != nil {
+			return err
+		}
+	}
+
+	goedit.making = true
+	editorMessage(fmt.Sprintf("Running %s ...", cmd))
+
+	go func() {
+		out, err := exec.Command(goedit.shell, "-c", cmd).CombinedOutput()
+		goedit.events <- func() {
+			finishMake(title, string(out), err, jump)
+		}
+	}()
+
+	return nil
+}
+
+// finishMake fills the quickfix list from the output of a build, on the main
+// goroutine.
+func finishMake(title string, out string, err error, jump bool) {
+	goedit.making = false
+	if err != nil && !isExitError(err) {
+		editorError(shellError(err))
+		return
+	}
+
+	entries, perr := errorEntries(outputLines(out))
+	if perr != nil {
+		editorError(perr)
+		return
+	}
+
+	setList(&goedit.quickfix, title, entries)
+	goedit.quickfix.build = true
+	switch {
+	case goedit.quickfix.validCount() > 0 && jump:
+		if jerr := editorJumpToEntry(&goedit.quickfix, goedit.quickfix.idx, false); jerr != nil {
+			editorError(jerr)
+		}
+	case goedit.quickfix.validCount() > 0:
+		editorError(fmt.Errorf("%s: %d errors", title, goedit.quickfix.validCount()))
+	case err != nil:
+		editorError(shellError(err))
+	default:
+		editorMessage(fmt.Sprintf("%s: done", title))
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	OPT_BOOL   = 1
+	OPT_NUMBER = 2
+	OPT_STRING = 3
+)
+
+const (
+	SCOPE_GLOBAL = 1
+	SCOPE_BUFFER = 2
+	SCOPE_WINDOW = 3
+)
+
+// option describes a :set option. ptr points at the field holding the value
+// in effect (a *bool, *int or *string); for buffer and window local options
+// global holds the value new buffers and windows start with.
+type option struct {
+	name   string
+	short  string
+	kind   int
+	scope  int
+	def    interface{}
+	global interface{}
+	ptr    interface{}
+	parse  func(string) (interface{}, error)
+	check  func(interface{}) error
+	onSet  func()
+	// userSet records that :set gave the option a global value, which then
+	// wins over the defaults of syntax files.
+	userSet bool
+}
+
+var options []*option
+
+// initOptions builds the option registry and gives every option its default.
+func initOptions() {
+	positive := func(v interface{}) error {
+		if v.(int) <= 0 {
+			return exErr(487, "Argument must be positive", "")
+		}
+		return nil
+	}
+	nonNegative := func(v interface{}) error {
+		if v.(int) < 0 {
+			return exErr(487, "Argument must be positive", "")
+		}
+		return nil
+	}
+	color := func(s string) (interface{}, error) {
+		return parseColor(s)
+	}
+	rerender := func() {
+		goedit.updateAllRows()
+	}
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "sh"
+	}
+
+	options = []*option{
+		{name: "autoindent", short: "ai", kind: OPT_BOOL, scope: SCOPE_BUFFER, def: true, ptr: &goedit.indent.AutoIndent},
+		{name: "smartindent", short: "si", kind: OPT_BOOL, scope: SCOPE_BUFFER, def: false, ptr: &goedit.indent.SmartIndent},
+		{name: "expandtab", short: "et", kind: OPT_BOOL, scope: SCOPE_BUFFER, def: false, ptr: &goedit.indent.ExpandTab},
+		{name: "tabstop", short: "ts", kind: OPT_NUMBER, scope: SCOPE_BUFFER, def: TAB_STOP, ptr: &goedit.indent.TabStop, check: positive, onSet: rerender},
+		{name: "shiftwidth", short: "sw", kind: OPT_NUMBER, scope: SCOPE_BUFFER, def: TAB_STOP, ptr: &goedit.indent.ShiftWidth, check: nonNegative},
+		{name: "softtabstop", short: "sts", kind: OPT_NUMBER, scope: SCOPE_BUFFER, def: 0, ptr: &goedit.indent.SoftTabStop, check: nonNegative},
+		{name: "number", short: "nu", kind: OPT_BOOL, scope: SCOPE_WINDOW, def: true, ptr: &goedit.number, onSet: goedit.updateGutter},
+		{name: "wrap", kind: OPT_BOOL, scope: SCOPE_WINDOW, def: false, ptr: &goedit.wrap},
+		{name: "linebreak", short: "lbr", kind: OPT_BOOL, scope: SCOPE_WINDOW, def: false, ptr: &goedit.linebreak},
+		{name: "showbreak", short: "sbr", kind: OPT_STRING, scope: SCOPE_GLOBAL, def: "", ptr: &goedit.showbreak},
+		{name: "ignorecase", short: "ic", kind: OPT_BOOL, scope: SCOPE_GLOBAL, def: false, ptr: &goedit.ignoreCase},
+		{name: "smartcase", short: "scs", kind: OPT_BOOL, scope: SCOPE_GLOBAL, def: false, ptr: &goedit.smartCase},
+		{name: "wrapscan", short: "ws", kind: OPT_BOOL, scope: SCOPE_GLOBAL, def: true, ptr: &goedit.wrapScan},
+		{name: "incsearch", short: "is", kind: OPT_BOOL, scope: SCOPE_GLOBAL, def: true, ptr: &goedit.incSearch},
+		{name: "hlsearch", short: "hls", kind: OPT_BOOL, scope: SCOPE_GLOBAL, def: true, ptr: &goedit.hlSearch, onSet: func() {
+			goedit.hlHidden = false
+		}},
+		{name: "undolevels", short: "ul", kind: OPT_NUMBER, scope: SCOPE_GLOBAL, def: 100, ptr: &goedit.undoLevels},
+		{name: "timeout", short: "to", kind: OPT_BOOL, scope: SCOPE_GLOBAL, def: true, ptr: &goedit.timeout},
+		{name: "timeoutlen", short: "tm", kind: OPT_NUMBER, scope: SCOPE_GLOBAL, def: 1000, ptr: &goedit.timeoutLen},
+		{name: "mapleader", kind: OPT_STRING, scope: SCOPE_GLOBAL, def: "\\", ptr: &goedit.mapLeader},
+		{name: "shell", short: "sh", kind: OPT_STRING, scope: SCOPE_GLOBAL, def: shell, ptr: &goedit.shell},
+		{name: "formatonsave", short: "fos", kind: OPT_BOOL, scope: SCOPE_GLOBAL, def: false, ptr: &goedit.formatOnSave},
+		{name: "importsonsave", short: "ios", kind: OPT_BOOL, scope: SCOPE_GLOBAL, def: false, ptr: &goedit.importsOnSave},
+		{name: "lsp", kind: OPT_BOOL, scope: SCOPE_GLOBAL, def: true, ptr: &goedit.useLSP},
+		{name: "virtualtext", short: "vt", kind: OPT_BOOL, scope: SCOPE_GLOBAL, def: false, ptr: &goedit.virtualText},
+		{name: "lspprg", kind: OPT_STRING, scope: SCOPE_GLOBAL, def: "gopls", ptr: &goedit.lspPrg},
+		{name: "makeprg", short: "mp", kind: OPT_STRING, scope: SCOPE_GLOBAL, def: "go build ./...", ptr: &goedit.makePrg},
+		{name: "grepprg", short: "gp", kind: OPT_STRING, scope: SCOPE_GLOBAL, def: "grep -n $* /dev/null", ptr: &goedit.grepPrg},
+		{name: "grepformat", short: "gfm", kind: OPT_STRING, scope: SCOPE_GLOBAL, def: "%f:%l:%m", ptr: &goedit.grepFormat},
+		{name: "errorformat", short: "efm", kind: OPT_STRING, scope: SCOPE_GLOBAL, def: ERROR_FORMAT, ptr: &goedit.errorFormat},
+		{name: "errorfile", short: "ef", kind: OPT_STRING, scope: SCOPE_GLOBAL, def: "errors.err", ptr: &goedit.errorFile},
+		{name: "syntaxdir", kind: OPT_STRING, scope: SCOPE_GLOBAL, def: "", ptr: &goedit.syntaxDir},
+		{name: "logfile", kind: OPT_STRING, scope: SCOPE_GLOBAL, def: "", ptr: &goedit.logFile, check: func(v interface{}) error {
+			return setLogFile(v.(string))
+		}},
+		{name: "textcolor", kind: OPT_NUMBER, scope: SCOPE_GLOBAL, def: WHITE, ptr: &goedit.colors.text, parse: color, onSet: rerender},
+		{name: "numbercolor", kind: OPT_NUMBER, scope: SCOPE_GLOBAL, def: MAGENTA, ptr: &goedit.colors.number, parse: color, onSet: rerender},
+		{name: "linenrcolor", kind: OPT_NUMBER, scope: SCOPE_GLOBAL, def: GREEN, ptr: &goedit.colors.lineNr, parse: color},
+		{name: "nontextcolor", kind: OPT_NUMBER, scope: SCOPE_GLOBAL, def: BLUE, ptr: &goedit.colors.nonText, parse: color},
+		{name: "searchcolor", kind: OPT_NUMBER, scope: SCOPE_GLOBAL, def: YELLOW, ptr: &goedit.colors.search, parse: color},
+	}
+
+	for _, o := range options {
+		o.global = o.def
+		o.store(o.def)
+	}
+}
+
+func lookupOption(name string) *option {
+	for _, o := range options {
+		if o.name == name || (o.short != "" && o.short == name) {
+			return o
+		}
+	}
+
+	return nil
+}
+
+func (o *option) load() interface{} {
+	switch p := o.ptr.(type) {
+	case *bool:
+		return *p
+	case *int:
+		return *p
+	case *string:
+		return *p
+	}
+
+	return nil
+}
+
+func (o *option) store(v interface{}) {
+	switch p := o.ptr.(type) {
+	case *bool:
+		*p = v.(bool)
+	case *int:
+		*p = v.(int)
+	case *string:
+		*p = v.(string)
+	}
+}
+
+// assign sets the option to v, and also its global value unless local is set.
+func (o *option) assign(v interface{}, local bool) error {
+	if o.check != nil {
+		if err := o.check(v); err != nil {
+			return err
+		}
+	}
+
+	o.store(v)
+	if !local || o.scope == SCOPE_GLOBAL {
+		o.global = v
+		o.userSet = true
+	}
+
+	if o.onSet != nil {
+		o.onSet()
+	}
+
+	return nil
+}
+
+// String formats the option the way :set shows it.
+func (o *option) String() string {
+	v := o.load()
+	switch o.kind {
+	case OPT_BOOL:
+		if v.(bool) {
+			return o.name
+		}
+		return "no" + o.name
+	case OPT_NUMBER:
+		return fmt.Sprintf("%s=%d", o.name, v)
+	}
+
+	return fmt.Sprintf("%s=%s", o.name, v)
+}
+
+// resetLocalOptions sets every option of the given scope back to its global
+// value, as when a new buffer is opened.
+func resetLocalOptions(scope int) {
+	for _, o := range options {
+		if o.scope == scope {
+			o.store(o.global)
+			if o.onSet != nil {
+				o.onSet()
+			}
+		}
+	}
+}
+
+// bufferOptions returns the values of the buffer options, for
+// restoreBufferOptions to put back the ones set for the buffer alone.
+func bufferOptions() map[*option]interface{} {
+	values := map[*option]interface{}{}
+	for _, o := range options {
+		if o.scope == SCOPE_BUFFER {
+			values[o] = o.load()
+		}
+	}
+
+	return values
+}
+
+// restoreBufferOptions gives the buffer options back the values in values
+// that differ from their global value, as :setlocal or the syntax file left
+// them.
+func restoreBufferOptions(values map[*option]interface{}) {
+	for o, v := range values {
+		if v != o.global {
+			o.store(v)
+			if o.onSet != nil {
+				o.onSet()
+			}
+		}
+	}
+}
+
+// setOption applies a single :set argument: "opt", "noopt", "invopt", "opt!",
+// "opt=val", "opt?" or "opt&". With local set, as for :setlocal, buffer and
+// window options keep their global value. It returns the text to show for
+// queries.
+func setOption(arg string, local bool) (string, error) {
+	if arg == "" {
+		return "", nil
+	}
+
+	name, value, hasValue := strings.Cut(arg, "=")
+	if !hasValue {
+		name, value, hasValue = strings.Cut(arg, ":")
+	}
+
+	suffix := ""
+	if !hasValue && (strings.HasSuffix(name, "?") || strings.HasSuffix(name, "&") || strings.HasSuffix(name, "!")) {
+		suffix = name[len(name)-1:]
+		name = name[:len(name)-1]
+	}
+
+	o := lookupOption(name)
+	prefix := ""
+	if o == nil && !hasValue {
+		for _, p := range []string{"no", "inv"} {
+			if strings.HasPrefix(name, p) {
+				if o = lookupOption(name[len(p):]); o != nil && o.kind == OPT_BOOL {
+					prefix = p
+					break
+				}
+				o = nil
+			}
+		}
+	}
+
+	if o == nil {
+		return "", exErr(518, "Unknown option", arg)
+	}
+
+	switch {
+	case suffix == "?":
+		return o.String(), nil
+	case suffix == "&":
+		if err := o.assign(o.def, local); err != nil {
+			return "", err
+		}
+		if !local {
+			o.userSet = false
+		}
+		return "", nil
+	case o.kind == OPT_BOOL:
+		if hasValue {
+			return "", exErr(474, "Invalid argument", arg)
+		}
+
+		switch {
+		case prefix == "no":
+			return "", o.assign(false, local)
+		case prefix == "inv" || suffix == "!":
+			return "", o.assign(!o.load().(bool), local)
+		}
+		return "", o.assign(true, local)
+	case !hasValue:
+		return o.String(), nil
+	}
+
+	var v interface{}
+	var err error
+	switch {
+	case o.parse != nil:
+		v, err = o.parse(value)
+	case o.kind == OPT_NUMBER:
+		v, err = strconv.Atoi(value)
+		if err != nil {
+			err = exErr(521, "Number required after =", arg)
+		}
+	default:
+		v = value
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if err := o.assign(v, local); err != nil {
+		if e, ok := err.(exError); ok && e.arg == "" {
+			e.arg = arg
+			return "", e
+		}
+		return "", err
+	}
+
+	return "", nil
+}
+
+// editorSetCommand runs :set or :setlocal with the given arguments.
+func editorSetCommand(args []string, local bool) error {
+	if len(args) == 0 || args[0] == "all" {
+		var lines []string
+		for _, o := range options {
+			if len(args) > 0 || o.load() != o.def {
+				lines = append(lines, "  "+o.String())
+			}
+		}
+		sort.Strings(lines)
+		editorShowLines(append([]string{"--- Options ---"}, lines...))
+		return nil
+	}
+
+	var shown []string
+	for _, arg := range args {
+		msg, err := setOption(arg, local)
+		if err != nil {
+			return err
+		}
+
+		if msg != "" {
+			shown = append(shown, "  "+msg)
+		}
+	}
+
+	if len(shown) > 0 {
+		editorMessage(strings.Join(shown, ""))
+	}
+
+	return nil
+}
+
+// updateAllRows re-renders every row, e.g. after the tab width changed.
+func (e *editor) updateAllRows() {
+	for i := range e.rows {
+		e.rows[i].updateRow()
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetRejectsNegativeIndent(t *testing.T) {
+	defer initOptions()
+	initOptions()
+
+	for _, arg := range []string{"sw=-1", "sts=-1", "ts=0"} {
+		_, err := setOption(arg, false)
+		if e, ok := err.(exError); !ok || e.code != 487 {
+			t.Errorf(":set %s = %v, want E487", arg, err)
+		}
+	}
+	if goedit.indent.ShiftWidth != TAB_STOP || goedit.indent.SoftTabStop != 0 {
+		t.Errorf("indent = %+v after rejected values, want the defaults", goedit.indent)
+	}
+
+	for _, arg := range []string{"sw=0", "sts=0", "sw=4"} {
+		if _, err := setOption(arg, false); err != nil {
+			t.Errorf(":set %s = %v", arg, err)
+		}
+	}
+}
+
+func TestSaveasKeepsLocalOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goedit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer initOptions()
+	initOptions()
+	defer func() { goedit.filename, goedit.rows, goedit.numOfRows = "", nil, 0 }()
+
+	goedit.filename = filepath.Join(dir, "a.txt")
+	goedit.rows = testRows("x")
+	goedit.numOfRows = 1
+	if _, err := setOption("sw=6", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := exSaveas(&exCmd{arg: filepath.Join(dir, "b.txt")}); err != nil {
+		t.Fatal(err)
+	}
+	if goedit.indent.ShiftWidth != 6 {
+		t.Errorf("shiftwidth after :saveas = %d, want the :setlocal 6", goedit.indent.ShiftWidth)
+	}
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// QF_HEIGHT is how many entries the quickfix window shows by default.
+const QF_HEIGHT = 10
+
+// qfEntry is a position in a file with a message, such as a compiler error
+// or a :vimgrep match. line and col are 1-based and 0 when unknown. kind is
+// 'E', 'W', 'I' or 'N' for an error, warning, info or note, or 0. Entries
+// that are not valid are lines of tool output that named no position.
+type qfEntry struct {
+	filename string
+	line     int
+	col      int
+	kind     byte
+	text     string
+	valid    bool
+}
+
+// kindName spells out the type of the entry.
+func (e qfEntry) kindName() string {
+	switch e.kind {
+	case 'E':
+		return "error"
+	case 'W':
+		return "warning"
+	case 'I':
+		return "info"
+	case 'N':
+		return "note"
+	}
+
+	return ""
+}
+
+// position returns the part of the entry between bars in the quickfix
+// window, as in "12 col 5 error".
+func (e qfEntry) position() string {
+	var parts []string
+	if e.line > 0 {
+		parts = append(parts, strconv.Itoa(e.line))
+		if e.col > 0 {
+			parts = append(parts, fmt.Sprintf("col %d", e.col))
+		}
+	}
+	if kind := e.kindName(); kind != "" {
+		parts = append(parts, kind)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func (e qfEntry) String() string {
+	if !e.valid {
+		return "|| " + e.text
+	}
+
+	return fmt.Sprintf("%s|%s| %s", e.filename, e.position(), e.text)
+}
+
+// qfList is a list of positions to step through; idx is the current entry.
+// The quickfix list is global, the location list belongs to the window.
+// build is set when the entries are errors from a build, which are shown as
+// diagnostics in the buffer.
+type qfList struct {
+	title   string
+	entries []qfEntry
+	idx     int
+	build   bool
+}
+
+// qfWindow is the pane below the text listing the quickfix or location
+// list. sel is the entry the cursor is on while the window has the focus.
+type qfWindow struct {
+	open   bool
+	list   *qfList
+	height int
+	top    int
+	sel    int
+	focus  bool
+}
+
+// listFor returns the list an ex command works on: the location list for
+// the :l commands and the quickfix list for the others. The location list
+// belongs to the window, and the editor has just the one.
+func listFor(c *exCmd) *qfList {
+	if c.def.name[0] == 'l' {
+		return &goedit.locList
+	}
+
+	return &goedit.quickfix
+}
+
+// emptyListError is the error for stepping through an empty list.
+func emptyListError(list *qfList) error {
+	if list == &goedit.locList {
+		return exErr(776, "No location list", "")
+	}
+
+	return exErr(42, "No Errors", "")
+}
+
+// setList replaces the entries of list, making the first valid one current.
+func setList(list *qfList, title string, entries []qfEntry) {
+	list.title, list.entries, list.idx, list.build = title, entries, 0, false
+	if i, ok := list.step(-1, 1, 1); ok {
+		list.idx = i
+	}
+
+	if goedit.qfWin.list == list {
+		goedit.qfWin.sel, goedit.qfWin.top = list.idx, 0
+	}
+}
+
+// fillList sets list from entries and jumps to the first valid entry, unless
+// jump is not set or there is none. force is as for editorJumpToEntry.
+func fillList(list *qfList, title string, entries []qfEntry, jump bool, force bool) error {
+	setList(list, title, entries)
+	valid := list.validCount()
+	if valid == 0 {
+		editorMessage(fmt.Sprintf("(%d entries, none with a position)", len(entries)))
+		return nil
+	}
+
+	if !jump {
+		e := list.entries[list.idx]
+		editorMessage(fmt.Sprintf("(1 of %d): %s", valid, e.text))
+		return nil
+	}
+
+	return editorJumpToEntry(list, list.idx, force)
+}
+
+// step returns the valid entry count valid entries away from entry from in
+// direction dir, or the last one found before running off the list, and
+// reports false when there is none.
+func (l *qfList) step(from int, dir int, count int) (int, bool) {
+	found, ok := from, false
+	for i := from + dir; i >= 0 && i < len(l.entries) && count > 0; i += dir {
+		if l.entries[i].valid {
+			found, ok = i, true
+			count--
+		}
+	}
+
+	return found, ok
+}
+
+func (l *qfList) validCount() int {
+	n := 0
+	for _, e := range l.entries {
+		if e.valid {
+			n++
+		}
+	}
+
+	return n
+}
+
+// validIndex returns the position of entry i among the valid entries.
+func (l *qfList) validIndex(i int) int {
+	n := 0
+	for _, e := range l.entries[:i+1] {
+		if e.valid {
+			n++
+		}
+	}
+
+	return n
+}
+
+func sameFile(a string, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+
+	return absA == absB
+}
+
+// editorJumpToEntry makes entry i of list current and moves the cursor to it.
+func editorJumpToEntry(list *qfList, i int, force bool) error {
+	e := list.entries[i]
+	if !e.valid {
+		return exErr(42, "No Errors", "")
+	}
+
+	if err := editorGotoEntry(e, force); err != nil {
+		return err
+	}
+
+	list.idx = i
+	if goedit.qfWin.list == list {
+		goedit.qfWin.sel = i
+	}
+
+	msg := e.text
+	if kind := e.kindName(); kind != "" {
+		msg = kind + ": " + msg
+	}
+	editorMessage(fmt.Sprintf("(%d of %d): %s", list.validIndex(i), list.validCount(), msg))
+	return nil
+}
+
+// editorGotoEntry moves the cursor to the position of e, opening its file
+// when that is not the one being edited, and records the jump. With force
+// set a modified buffer is abandoned for it.
+func editorGotoEntry(e qfEntry, force bool) error {
+	from := currentJump()
+	if e.filename != "" && !sameFile(e.filename, goedit.filename) {
+		if err := editorEditFile(e.filename, force); err != nil {
+			return err
+		}
+	}
+	pushJump(from)
+
+	if e.line > 0 {
+		editorGotoLine(e.line)
+	}
+	if e.col > 0 && goedit.cursor.y < goedit.numOfRows {
+		goedit.cursor.x = e.col - 1
+		if size := goedit.rows[goedit.cursor.y].size; goedit.cursor.x >= size {
+			goedit.cursor.x = size - 1
+		}
+		if goedit.cursor.x < 0 {
+			goedit.cursor.x = 0
+		}
+	}
+
+	return nil
+}
+
+// qfCount parses the [count] argument of :cnext and friends.
+func qfCount(arg string) (int, error) {
+	if arg == "" {
+		return 1, nil
+	}
+
+	n, err := strconv.Atoi(arg)
+	if err != nil || n <= 0 {
+		return 0, exErr(488, "Trailing characters", arg)
+	}
+
+	return n, nil
+}
+
+// exCnext implements :cn[ext] [count], :cp[revious] [count] and :cN[ext]
+// [count], and their location list versions :lne[xt], :lp[revious] and
+// :lN[ext].
+func exCnext(c *exCmd) error {
+	list := listFor(c)
+	if list.validCount() == 0 {
+		return emptyListError(list)
+	}
+
+	n, err := qfCount(c.arg)
+	if err != nil {
+		return err
+	}
+
+	dir := -1
+	if c.def.name[1:] == "next" {
+		dir = 1
+	}
+
+	i, ok := list.step(list.idx, dir, n)
+	if !ok {
+		return exErr(553, "No more items", "")
+	}
+
+	return editorJumpToEntry(list, i, c.bang)
+}
+
+// exCc implements :cc [nr] and :ll [nr], jumping to entry nr or the current
+// one.
+func exCc(c *exCmd) error {
+	list := listFor(c)
+	if list.validCount() == 0 {
+		return emptyListError(list)
+	}
+
+	i := list.idx
+	if c.arg != "" {
+		n, err := qfCount(c.arg)
+		if err != nil {
+			return err
+		}
+
+		i, _ = list.step(-1, 1, n)
+	}
+
+	return editorJumpToEntry(list, i, c.bang)
+}
+
+// exCopen implements :cope[n] [height] and :lop[en] [height], opening the
+// window for the list and moving the focus to it.
+func exCopen(c *exCmd) error {
+	list := listFor(c)
+	if list == &goedit.locList && list.title == "" {
+		return exErr(776, "No location list", "")
+	}
+
+	height := QF_HEIGHT
+	if c.arg != "" {
+		n, err := qfCount(c.arg)
+		if err != nil {
+			return err
+		}
+		height = n
+	}
+
+	openQuickfixWindow(list, height)
+	editorQuickfixWindow()
+	return nil
+}
+
+// exCclose implements :ccl[ose] and :lcl[ose].
+func exCclose(c *exCmd) error {
+	if goedit.qfWin.list == listFor(c) {
+		closeQuickfixWindow()
+	}
+
+	return nil
+}
+
+// exCfile implements :cf[ile][!] [errorfile] and :lf[ile][!] [errorfile],
+// reading the list from a file written by a compiler. With ! a modified
+// buffer is abandoned to jump to the first error.
+func exCfile(c *exCmd) error {
+	filename := c.arg
+	if filename == "" {
+		filename = goedit.errorFile
+	}
+
+	lines, err := readLines(filename)
+	if err != nil {
+		return exErr(40, "Can't open errorfile", filename)
+	}
+
+	entries, err := errorEntries(lines)
+	if err != nil {
+		return err
+	}
+
+	return fillList(listFor(c), ":"+c.def.name+" "+filename, entries, true, c.bang)
+}
+
+// exCexpr implements :cex[pr][!] {expr} and :lex[pr][!] {expr}, which fill
+// the list from the lines of a string or of the output of system({cmd}).
+func exCexpr(c *exCmd) error {
+	text, err := evalStringExpr(strings.TrimSpace(c.arg))
+	if err != nil {
+		return err
+	}
+
+	entries, err := errorEntries(strings.Split(text, "\n"))
+	if err != nil {
+		return err
+	}
+
+	return fillList(listFor(c), ":"+c.def.name+" "+c.arg, entries, true, c.bang)
+}
+
+// evalStringExpr evaluates the expressions :cexpr accepts: a single or double
+// quoted string, the latter with backslash escapes, or system({string}),
+// which runs a shell command and gives its output.
+func evalStringExpr(expr string) (string, error) {
+	if strings.HasPrefix(expr, "system(") && strings.HasSuffix(expr, ")") {
+		cmd, err := evalStringExpr(strings.TrimSpace(expr[len("system(") : len(expr)-1]))
+		if err != nil {
+			return "", err
+		}
+
+		out, err := runFilter(cmd, "")
+		if err != nil && !isExitError(err) {
+			return "", shellError(err)
+		}
+
+		return out, nil
+	}
+
+	if len(expr) >= 2 && expr[0] == '\'' && expr[len(expr)-1] == '\'' {
+		return strings.Replace(expr[1:len(expr)-1], "''", "'", -1), nil
+	}
+
+	if len(expr) >= 2 && expr[0] == '"' && expr[len(expr)-1] == '"' {
+		s, err := strconv.Unquote(expr)
+		if err != nil {
+			return "", exErr(15, "Invalid expression", expr)
+		}
+
+		return s, nil
+	}
+
+	return "", exErr(15, "Invalid expression", expr)
+}
+
+// openQuickfixWindow shows the window for list with height lines, taking
+// them and a status line from the text area.
+func openQuickfixWindow(list *qfList, height int) {
+	closeQuickfixWindow()
+	if max := goedit.height - 2; height > max {
+		height = max
+	}
+	if height < 1 {
+		return
+	}
+
+	goedit.qfWin = qfWindow{open: true, list: list, height: height, sel: list.idx}
+	goedit.height -= height + 1
+}
+
+func closeQuickfixWindow() {
+	if goedit.qfWin.open {
+		goedit.height += goedit.qfWin.height + 1
+	}
+	goedit.qfWin = qfWindow{}
+}
+
+// messageRow returns the 0-based screen row of the message bar.
+func (e *editor) messageRow() int {
+	row := e.height + 1
+	if e.qfWin.open {
+		row += e.qfWin.height + 1
+	}
+	if e.outWin.open {
+		row += e.outWin.height + 1
+	}
+
+	return row
+}
+
+// drawQuickfixEntry draws an entry the way vim's quickfix buffer shows it,
+// the file name and position coloured like a path and a line number.
+func drawQuickfixEntry(e qfEntry, width int) {
+	line := e.String()
+	if len(line) > width {
+		line = line[:width]
+	}
+
+	if !e.valid || len(e.filename)+len(e.position())+2 > len(line) {
+		goedit.editorUI.WriteString(line)
+		return
+	}
+
+	name := len(e.filename)
+	pos := name + len(e.position()) + 2
+	goedit.editorUI.WriteString(fmt.Sprintf("\x1b[%dm%s\x1b[39m", goedit.colors.number, line[:name]))
+	goedit.editorUI.WriteString(fmt.Sprintf("|\x1b[%dm%s\x1b[39m|", goedit.colors.lineNr, line[name+1:pos-1]))
+	goedit.editorUI.WriteString(line[pos:])
+}
+
+// drawQuickfix draws the quickfix window and its status line below the
+// status bar of the text.
+func drawQuickfix() {
+	w := &goedit.qfWin
+	list := w.list
+	if w.sel < w.top {
+		w.top = w.sel
+	}
+	if w.sel >= w.top+w.height {
+		w.top = w.sel - w.height + 1
+	}
+
+	for i := 0; i < w.height; i++ {
+		n := w.top + i
+		switch {
+		case n >= len(list.entries):
+			goedit.editorUI.WriteString(fmt.Sprintf("\x1b[%dm~\x1b[39m", goedit.colors.nonText))
+		case n == w.sel && w.focus:
+			goedit.editorUI.WriteString("\x1b[7m")
+			drawQuickfixEntry(list.entries[n], goedit.width)
+			goedit.editorUI.WriteString("\x1b[m")
+		case n == list.idx:
+			goedit.editorUI.WriteString("\x1b[1m")
+			drawQuickfixEntry(list.entries[n], goedit.width)
+			goedit.editorUI.WriteString("\x1b[m")
+		default:
+			drawQuickfixEntry(list.entries[n], goedit.width)
+		}
+
+		goedit.editorUI.WriteString("\x1b[K\r\n")
+	}
+
+	name := "[Quickfix List]"
+	if list == &goedit.locList {
+		name = "[Location List]"
+	}
+	status := fmt.Sprintf("%s %s", name, list.title)
+	pos := fmt.Sprintf("%d/%d", w.sel+1, len(list.entries))
+	room := goedit.width - len(pos) - 1
+	if room < 0 {
+		room = 0
+	}
+	if len(status) > room {
+		status = status[:room]
+	}
+	pad := goedit.width - len(status) - len(pos)
+	if pad < 0 {
+		pad = 0
+	}
+	goedit.editorUI.WriteString("\x1b[7m")
+	goedit.editorUI.WriteString(status)
+	goedit.editorUI.WriteString(strings.Repeat(" ", pad))
+	goedit.editorUI.WriteString(pos)
+	goedit.editorUI.WriteString("\x1b[m\r\n")
+}
+
+// editorQuickfixWindow gives the focus to the quickfix window until Escape
+// is pressed. It moves like a buffer with j, k, gg, G and Ctrl-D and Ctrl-U;
+// Enter jumps to an entry and q closes the window.
+func editorQuickfixWindow() {
+	w := &goedit.qfWin
+	if !w.open {
+		return
+	}
+
+	w.focus = true
+	defer func() { w.focus = false }()
+
+	for {
+		last := len(w.list.entries) - 1
+		clearScreen()
+		switch readKey() {
+		case 'j', CURSOR_DOWN:
+			w.sel++
+		case 'k', CURSOR_UP:
+			w.sel--
+		case CTRL_D:
+			w.sel += w.height / 2
+		case CTRL_U:
+			w.sel -= w.height / 2
+		case 'g':
+			if readKey() == 'g' {
+				w.sel = 0
+			}
+		case 'G':
+			w.sel = last
+		case '\r':
+			if w.sel <= last {
+				if err := editorJumpToEntry(w.list, w.sel, false); err != nil {
+					editorError(err)
+					break
+				}
+				return
+			}
+		case 'q':
+			closeQuickfixWindow()
+			return
+		case '\x1b':
+			return
+		}
+
+		if w.sel > last {
+			w.sel = last
+		}
+		if w.sel < 0 {
+			w.sel = 0
+		}
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// searchOffset is where a search leaves the cursor relative to the match, as
+// in "/foo/e+1". With lines set it is n lines below or above the match;
+// otherwise kind is 's' or 'e' for n characters from its start or end, or 0
+// for the start itself.
+type searchOffset struct {
+	kind  byte
+	lines bool
+	n     int
+}
+
+// searchMatch is a match of a search pattern on row y, from byte start to
+// byte end of the row's text.
+type searchMatch struct {
+	y, start, end int
+}
+
+// compilePattern compiles a search pattern using the ignorecase and
+// smartcase options.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	return compileRegexp(pattern, goedit.smartCase)
+}
+
+// compileRegexp compiles a search pattern. \c or \C anywhere in it makes it
+// ignore or match case; otherwise case is ignored when ignorecase is set,
+// unless smartCase is too and the pattern has an upper case letter. \< and
+// \> match at the start and end of a word.
+func compileRegexp(pattern string, smartCase bool) (*regexp.Regexp, error) {
+	ignore := goedit.ignoreCase
+	forced, upper := false, false
+
+	buf := strings.Builder{}
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '\\' || i+1 >= len(pattern) {
+			upper = upper || unicode.IsUpper(rune(pattern[i]))
+			buf.WriteByte(pattern[i])
+			continue
+		}
+
+		i++
+		switch pattern[i] {
+		case 'c':
+			ignore, forced = true, true
+		case 'C':
+			if !forced {
+				ignore, forced = false, true
+			}
+		case '<', '>':
+			buf.WriteString(`\b`)
+		default:
+			buf.WriteByte('\\')
+			buf.WriteByte(pattern[i])
+		}
+	}
+
+	expr := buf.String()
+	if !forced && smartCase && upper {
+		ignore = false
+	}
+	if ignore {
+		expr = "(?i)" + expr
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, exErr(383, "Invalid search string", pattern)
+	}
+
+	return re, nil
+}
+
+// parseSearchOffset parses the offset after a search pattern: [+-]N for
+// lines, or e, s or b followed by an optional [+-]N for characters.
+func parseSearchOffset(s string) (searchOffset, error) {
+	off := searchOffset{lines: true}
+	if s == "" {
+		off.lines = false
+		return off, nil
+	}
+
+	switch s[0] {
+	case 'e':
+		off.kind, off.lines = 'e', false
+		s = s[1:]
+	case 's', 'b':
+		off.kind, off.lines = 's', false
+		s = s[1:]
+	}
+
+	if s == "" {
+		return off, nil
+	}
+
+	sign := 1
+	switch s[0] {
+	case '+':
+		s = s[1:]
+	case '-':
+		sign = -1
+		s = s[1:]
+	default:
+		if !off.lines {
+			return off, exErr(488, "Trailing characters", s)
+		}
+	}
+
+	off.n = sign
+	if s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return off, exErr(488, "Trailing characters", s)
+		}
+		off.n = sign * n
+	}
+
+	return off, nil
+}
+
+// findMatch looks in rows for the first match of re after position from, or
+// the last one before it when searching backward, wrapping around the end
+// when wrap is set. It reports whether the search wrapped. Positions are
+// byte offsets into the rows' text, never render columns, so tabs need no
+// special care. A match after the last character of a row, such as one of
+// "$", counts as being on that character, where the cursor would be left.
+func findMatch(rows []erow, re *regexp.Regexp, from cursor, forward bool, wrap bool) (searchMatch, bool, bool) {
+	n := len(rows)
+	if n == 0 {
+		return searchMatch{}, false, false
+	}
+
+	if from.y >= n {
+		from.y = n - 1
+	}
+
+	for i := 0; i <= n; i++ {
+		y := from.y + i
+		if !forward {
+			y = from.y - i
+		}
+		wrapped := y < 0 || y >= n
+		y = (y%n + n) % n
+
+		if wrapped && !wrap {
+			break
+		}
+
+		last := rows[y].size - 1
+		var match searchMatch
+		found := false
+		for _, m := range re.FindAllStringIndex(rows[y].text(), -1) {
+			col := m[0]
+			if col > last && last >= 0 {
+				col = last
+			}
+
+			switch {
+			case i == 0 && forward && col <= from.x:
+				continue
+			case i == 0 && !forward && col >= from.x:
+				continue
+			case i == n && forward && col > from.x:
+				continue
+			case i == n && !forward && col < from.x:
+				continue
+			}
+
+			match, found = searchMatch{y: y, start: m[0], end: m[1]}, true
+			if forward {
+				break
+			}
+		}
+
+		if found {
+			return match, wrapped, true
+		}
+	}
+
+	return searchMatch{}, false, false
+}
+
+// editorSearchPrompt reads "pattern/offset" after / or ? and searches for it.
+// An empty pattern reuses the last one, and an empty line the last offset
+// too.
+func editorSearchPrompt(forward bool) {
+	delim := byte('/')
+	if !forward {
+		delim = '?'
+	}
+
+	start, rowOff, colOff := goedit.cursor, goedit.rowOffSet, goedit.colOffSet
+	var incsearch func(string)
+	if goedit.incSearch {
+		incsearch = func(input string) {
+			editorIncSearch(input, delim, start, rowOff, colOff)
+		}
+	}
+
+	input, ok := editorReadPrompt(string(delim), incsearch)
+	goedit.curMatch = nil
+	goedit.rowOffSet, goedit.colOffSet = rowOff, colOff
+	if !ok {
+		return
+	}
+
+	pattern, rest := splitDelimited(input, delim)
+	offset := goedit.search.offset
+	if pattern != "" || rest != "" {
+		var err error
+		if offset, err = parseSearchOffset(rest); err != nil {
+			editorError(err)
+			return
+		}
+	}
+
+	if pattern == "" {
+		pattern = goedit.search.query
+	}
+
+	if pattern == "" {
+		editorError(exErr(35, "No previous regular expression", ""))
+		return
+	}
+
+	goedit.search = searchObject{query: pattern, forward: forward, offset: offset}
+	editorSearchNext(forward)
+}
+
+// editorIncSearch shows the first match of the pattern typed so far after a
+// / or ? prompt, searching from start, the cursor before the prompt. The
+// view goes back to rowOff and colOff when nothing matches.
+func editorIncSearch(input string, delim byte, start cursor, rowOff int, colOff int) {
+	goedit.curMatch = nil
+	goedit.rowOffSet, goedit.colOffSet = rowOff, colOff
+
+	pattern, _ := splitDelimited(input, delim)
+	if pattern == "" || goedit.numOfRows == 0 {
+		return
+	}
+
+	re, err := compilePattern(pattern)
+	if err != nil {
+		return
+	}
+
+	m, _, found := findMatch(goedit.rows, re, start, delim == '/', goedit.wrapScan)
+	if !found {
+		return
+	}
+
+	row := goedit.rows[m.y]
+	goedit.curMatch = &matchRegion{y: m.y, start: cursorxToRx(row, m.start), end: cursorxToRx(row, m.end)}
+
+	// Scroll the match into view, then give the cursor back to the prompt.
+	prompt, mode := goedit.cursor, goedit.mode
+	goedit.cursor, goedit.mode = cursor{x: m.start, y: m.y}, NORMAL_MODE
+	scroll()
+	goedit.cursor, goedit.mode = prompt, mode
+}
+
+// editorSearchWord implements * and #: it searches for the keyword under or
+// after the cursor as a whole word. Like vim it does not use smartcase.
+func editorSearchWord(forward bool) {
+	if goedit.cursor.y >= goedit.numOfRows {
+		return
+	}
+
+	text := goedit.rows[goedit.cursor.y].text()
+	start := goedit.cursor.x
+	if start < len(text) && isKeywordChar(text[start]) {
+		for start > 0 && isKeywordChar(text[start-1]) {
+			start--
+		}
+	} else {
+		for start < len(text) && !isKeywordChar(text[start]) {
+			start++
+		}
+	}
+
+	end := start
+	for end < len(text) && isKeywordChar(text[end]) {
+		end++
+	}
+
+	if start == end {
+		editorError(exErr(348, "No string under cursor", ""))
+		return
+	}
+
+	goedit.search = searchObject{query: `\<` + regexp.QuoteMeta(text[start:end]) + `\>`, forward: forward, noSmartCase: true}
+	goedit.cursor.x = start
+	editorSearchNext(forward)
+}
+
+func isKeywordChar(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+// editorNextSearch repeats the last search in the same direction, as n.
+func editorNextSearch() {
+	editorSearchNext(goedit.search.forward)
+}
+
+// editorPrevSearch repeats the last search in the opposite direction, as N.
+func editorPrevSearch() {
+	editorSearchNext(!goedit.search.forward)
+}
+
+// editorSearchNext moves the cursor to the next match of the last search
+// pattern in the given direction and applies the search offset.
+func editorSearchNext(forward bool) {
+	s := goedit.search
+	if s.query == "" {
+		editorError(exErr(35, "No previous regular expression", ""))
+		return
+	}
+
+	re, err := compileRegexp(s.query, goedit.smartCase && !s.noSmartCase)
+	if err != nil {
+		editorError(err)
+		return
+	}
+
+	if goedit.numOfRows == 0 {
+		editorError(exErr(486, "Pattern not found", s.query))
+		return
+	}
+
+	// Start from where the match the cursor was left at by the offset
+	// would be, so the same match is not found again.
+	from := goedit.cursor
+	if from.y >= goedit.numOfRows {
+		from = cursor{x: -1, y: goedit.numOfRows - 1}
+	}
+
+	switch {
+	case s.offset.lines:
+		if y := from.y - s.offset.n; y >= 0 && y < goedit.numOfRows {
+			from.y = y
+		}
+		from.x = -1
+		if forward {
+			from.x = goedit.rows[from.y].size
+		}
+	case s.offset.kind != 0:
+		from.x -= s.offset.n
+	}
+
+	m, wrapped, found := findMatch(goedit.rows, re, from, forward, goedit.wrapScan)
+	if !found {
+		switch {
+		case goedit.wrapScan:
+			editorError(exErr(486, "Pattern not found", s.query))
+		case forward:
+			editorError(exErr(385, "Search hit BOTTOM without match for", s.query))
+		default:
+			editorError(exErr(384, "Search hit TOP without match for", s.query))
+		}
+		return
+	}
+
+	goedit.cursor = s.offset.apply(m)
+	goedit.hlHidden = false
+	goedit.search.count, goedit.search.countAt = countMatches(re, m), goedit.cursor
+
+	delim := "/"
+	if !forward {
+		delim = "?"
+	}
+	if s.offset != (searchOffset{}) {
+		editorMessage(delim + s.query + delim + s.offset.String())
+	} else {
+		editorMessage(delim + s.query)
+	}
+	if wrapped {
+		msg := "search hit BOTTOM, continuing at TOP"
+		if !forward {
+			msg = "search hit TOP, continuing at BOTTOM"
+		}
+		goedit.editormsg.msg = msg
+		goedit.editormsg.fgColor = RED
+	}
+}
+
+// apply returns the cursor position the offset gives for match m.
+func (o searchOffset) apply(m searchMatch) cursor {
+	pos := cursor{x: m.start, y: m.y}
+	switch {
+	case o.lines:
+		pos = cursor{x: 0, y: m.y + o.n}
+		if pos.y < 0 {
+			pos.y = 0
+		}
+		if pos.y >= goedit.numOfRows {
+			pos.y = goedit.numOfRows - 1
+		}
+	case o.kind == 'e':
+		pos.x = m.end - 1 + o.n
+		if m.end == m.start {
+			pos.x = m.start + o.n
+		}
+	case o.kind == 's':
+		pos.x += o.n
+	}
+
+	if size := goedit.rows[pos.y].size; pos.x >= size {
+		pos.x = size - 1
+	}
+	if pos.x < 0 {
+		pos.x = 0
+	}
+
+	return pos
+}
+
+// String formats the offset the way it is typed after a pattern.
+func (o searchOffset) String() string {
+	switch {
+	case o.lines:
+		return fmt.Sprintf("%+d", o.n)
+	case o.kind != 0 && o.n != 0:
+		return fmt.Sprintf("%c%+d", o.kind, o.n)
+	case o.kind != 0:
+		return string(o.kind)
+	}
+
+	return ""
+}
+
+// countMatches returns "[index/total]" for match m among all matches of re.
+func countMatches(re *regexp.Regexp, m searchMatch) string {
+	index, total := 0, 0
+	for y := range goedit.rows {
+		for _, loc := range re.FindAllStringIndex(goedit.rows[y].text(), -1) {
+			total++
+			if y == m.y && loc[0] == m.start {
+				index = total
+			}
+		}
+	}
+
+	return fmt.Sprintf("[%d/%d]", index, total)
+}
+
+var highlightCache struct {
+	key string
+	re  *regexp.Regexp
+}
+
+// highlightRegexp returns the regexp for the last search when hlsearch
+// should show its matches, or nil. It is cached between redraws.
+func highlightRegexp() *regexp.Regexp {
+	s := goedit.search
+	if !goedit.hlSearch || goedit.hlHidden || s.query == "" {
+		return nil
+	}
+
+	smartCase := goedit.smartCase && !s.noSmartCase
+	key := fmt.Sprintf("%t %t %s", goedit.ignoreCase, smartCase, s.query)
+	if highlightCache.key != key {
+		highlightCache.key = key
+		highlightCache.re, _ = compileRegexp(s.query, smartCase)
+	}
+
+	return highlightCache.re
+}
+
+// rowMatches returns the render columns of the non-empty matches of re on row.
+func rowMatches(re *regexp.Regexp, row erow) []matchRegion {
+	if re == nil {
+		return nil
+	}
+
+	var matches []matchRegion
+	for _, m := range re.FindAllStringIndex(row.text(), -1) {
+		if m[0] < m[1] {
+			matches = append(matches, matchRegion{start: cursorxToRx(row, m[0]), end: cursorxToRx(row, m[1])})
+		}
+	}
+
+	return matches
+}
+
+func inMatch(matches []matchRegion, col int) bool {
+	for _, m := range matches {
+		if col >= m.start && col < m.end {
+			return true
+		}
+	}
+
+	return false
+}
+
+// exNohlsearch implements :noh[lsearch], hiding the hlsearch highlighting
+// until the next search.
+func exNohlsearch(c *exCmd) error {
+	goedit.hlHidden = true
+	return nil
+}
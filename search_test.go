@@ -0,0 +1,88 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func testRows(lines ...string) []erow {
+	rows := make([]erow, len(lines))
+	for i, line := range lines {
+		rows[i].setChars(line)
+	}
+
+	return rows
+}
+
+func TestFindMatchTabs(t *testing.T) {
+	rows := testRows("\tfoo", "\t\tx := foo")
+	re := regexp.MustCompile(`foo`)
+
+	m, wrapped, found := findMatch(rows, re, cursor{x: 0, y: 0}, true, true)
+	if !found || wrapped || m != (searchMatch{y: 0, start: 1, end: 4}) {
+		t.Errorf("forward from 0,0 = %+v %v %v, want {0 1 4} on row 0", m, wrapped, found)
+	}
+
+	m, _, found = findMatch(rows, re, cursor{x: 1, y: 0}, true, true)
+	if !found || m != (searchMatch{y: 1, start: 7, end: 10}) {
+		t.Errorf("forward from 1,0 = %+v %v, want byte columns 7-10 on row 1", m, found)
+	}
+}
+
+func TestFindMatchSeveralPerLine(t *testing.T) {
+	rows := testRows("ab ab ab")
+	re := regexp.MustCompile(`ab`)
+
+	tests := []struct {
+		from    int
+		forward bool
+		start   int
+		wrapped bool
+	}{
+		{0, true, 3, false},
+		{3, true, 6, false},
+		{6, true, 0, true},
+		{6, false, 3, false},
+		{3, false, 0, false},
+		{0, false, 6, true},
+	}
+
+	for _, tt := range tests {
+		m, wrapped, found := findMatch(rows, re, cursor{x: tt.from, y: 0}, tt.forward, true)
+		if !found || m.start != tt.start || wrapped != tt.wrapped {
+			t.Errorf("from %d forward=%v: got start %d wrapped %v found %v, want %d %v",
+				tt.from, tt.forward, m.start, wrapped, found, tt.start, tt.wrapped)
+		}
+	}
+}
+
+func TestFindMatchLineBoundaries(t *testing.T) {
+	rows := testRows("one", "two", "three")
+
+	m, _, found := findMatch(rows, regexp.MustCompile(`^t`), cursor{x: 0, y: 0}, true, true)
+	if !found || m != (searchMatch{y: 1, start: 0, end: 1}) {
+		t.Errorf("^t from 0,0 = %+v %v, want start of row 1", m, found)
+	}
+
+	// "$" matches after the last character but counts as being on it, so
+	// searching forward from there moves on to the next row.
+	re := regexp.MustCompile(`$`)
+	m, _, found = findMatch(rows, re, cursor{x: 0, y: 0}, true, true)
+	if !found || m.y != 0 || m.start != 3 {
+		t.Errorf("$ from 0,0 = %+v %v, want end of row 0", m, found)
+	}
+	m, _, found = findMatch(rows, re, cursor{x: 2, y: 0}, true, true)
+	if !found || m.y != 1 {
+		t.Errorf("$ from 2,0 = %+v %v, want row 1", m, found)
+	}
+
+	m, wrapped, found := findMatch(rows, regexp.MustCompile(`e$`), cursor{x: 4, y: 2}, true, false)
+	if found {
+		t.Errorf("e$ from the last match without wrapscan = %+v %v, want no match", m, wrapped)
+	}
+
+	m, wrapped, found = findMatch(rows, regexp.MustCompile(`e$`), cursor{x: 4, y: 2}, true, true)
+	if !found || !wrapped || m.y != 0 || m.start != 2 {
+		t.Errorf("e$ from the last match with wrapscan = %+v %v %v, want row 0 column 2 wrapped", m, wrapped, found)
+	}
+}
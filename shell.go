@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// expandShellCommand replaces % in cmd by the file name and ! by the previous
+// command, unless escaped by a backslash, and remembers the result as the
+// previous command.
+func expandShellCommand(cmd string) (string, error) {
+	cmd, err := expandSpecial(cmd, true)
+	if err != nil {
+		return "", err
+	}
+
+	goedit.lastShell = cmd
+	return cmd, nil
+}
+
+// expandFileName replaces % in cmd by the file name unless escaped by a
+// backslash, for the commands such as :make and :grep whose ! is not the
+// previous shell command.
+func expandFileName(cmd string) (string, error) {
+	return expandSpecial(cmd, false)
+}
+
+// expandSpecial replaces % in cmd by the file name and, when bang is set, !
+// by the previous shell command.
+func expandSpecial(cmd string, bang bool) (string, error) {
+	buf := strings.Builder{}
+	for i := 0; i < len(cmd); i++ {
+		switch ch := cmd[i]; {
+		case ch == '\\' && i+1 < len(cmd) && (cmd[i+1] == '%' || bang && cmd[i+1] == '!'):
+			buf.WriteByte(cmd[i+1])
+			i++
+		case ch == '%':
+			if goedit.filename == "" {
+				return "", exErr(499, "Empty file name for '%'", "")
+			}
+			buf.WriteString(goedit.filename)
+		case ch == '!' && bang:
+			if goedit.lastShell == "" {
+				return "", exErr(34, "No previous command", "")
+			}
+			buf.WriteString(goedit.lastShell)
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+
+	return buf.String(), nil
+}
+
+// runShell runs cmd with the shell option. The terminal leaves raw mode and
+// the editor stops reading keys while the command runs, so a command with
+// the terminal as its standard input can use it.
+func runShell(c *exec.Cmd) error {
+	goedit.pauseInput <- true
+	goedit.resetMode()
+	err := c.Run()
+	rawMode()
+	goedit.pauseInput <- false
+
+	return err
+}
+
+// runFilter runs cmd with input on its standard input and returns what it
+// writes to its standard output and standard error.
+func runFilter(cmd string, input string) (string, error) {
+	c := exec.Command(goedit.shell, "-c", cmd)
+	c.Stdin = strings.NewReader(input)
+
+	var out bytes.Buffer
+	c.Stdout, c.Stderr = &out, &out
+	err := runShell(c)
+
+	return out.String(), err
+}
+
+// shellError describes the error from running a shell command.
+func shellError(err error) error {
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return fmt.Errorf("shell returned %d", exit.ExitCode())
+	}
+
+	return fmt.Errorf("cannot execute shell %s: %v", goedit.shell, err)
+}
+
+// isExitError reports whether err only says the command exited non-zero.
+func isExitError(err error) bool {
+	var exit *exec.ExitError
+	return errors.As(err, &exit)
+}
+
+// outputLines splits command output into lines.
+func outputLines(out string) []string {
+	out = strings.TrimSuffix(out, "\n")
+	if out == "" {
+		return nil
+	}
+
+	return strings.Split(out, "\n")
+}
+
+// exBang implements :!cmd, which runs cmd showing its output, and
+// :{range}!cmd, which replaces the lines of the range with the output of cmd
+// given them as input.
+func exBang(c *exCmd) error {
+	cmd, err := expandShellCommand(c.arg)
+	if err != nil {
+		return err
+	}
+
+	if c.addrs > 0 {
+		return filterLines(c.line1-1, c.line2-1, cmd)
+	}
+
+	editorShellOutput(cmd, "")
+	return nil
+}
+
+// editorShellOutput runs cmd with input on its standard input, or the
+// terminal when input is empty, showing its output over the whole screen until
+// a key is pressed.
+func editorShellOutput(cmd string, input string) {
+	goedit.reader.Write("\x1b[H\x1b[2J:!" + cmd + "\r\n")
+	sh := exec.Command(goedit.shell, "-c", cmd)
+	sh.Stdin = os.Stdin
+	if input != "" {
+		sh.Stdin = strings.NewReader(input)
+	}
+	sh.Stdout, sh.Stderr = os.Stdout, os.Stderr
+	if err := runShell(sh); err != nil {
+		goedit.reader.Write("\r\n" + shellError(err).Error() + "\r\n")
+	}
+
+	editorHitEnter()
+}
+
+// filterLines replaces rows first to last with the output of cmd run on them.
+// Like vim it does so even when cmd fails, which can be undone.
+func filterLines(first int, last int, cmd string) error {
+	out, err := runFilter(cmd, strings.Join(rangeLines(first, last), "\n")+"\n")
+	var exit *exec.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		return shellError(err)
+	}
+
+	saveUndo()
+	replaceLines(first, last, outputLines(out))
+	editorGotoLine(first + 1)
+
+	if err != nil {
+		return shellError(err)
+	}
+
+	n := last - first + 1
+	reportLines(n, fmt.Sprintf("%d lines filtered", n))
+
+	return nil
+}
+
+// readCommand puts the output of cmd below line, or above the first line
+// for line 0, as :r !cmd.
+func readCommand(line int, cmd string) error {
+	cmd, err := expandShellCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	out, err := runFilter(cmd, "")
+	var exit *exec.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		return shellError(err)
+	}
+
+	if lines := outputLines(out); len(lines) > 0 {
+		saveUndo()
+		replaceLines(line, line-1, lines)
+		editorGotoLine(line + 1)
+	}
+
+	if err != nil {
+		return shellError(err)
+	}
+
+	return nil
+}
+
+// editorFilterMotion implements !{motion} in normal mode. It reads a count
+// and a motion over whole lines (!, j, k, G, gg or 'x) and prompts for the
+// command to filter those lines through, as :{range}! would.
+func editorFilterMotion() {
+	count := 0
+	key := readKey()
+	for (key >= '1' && key <= '9') || (count > 0 && key == '0') {
+		count = count*10 + int(key-'0')
+		key = readKey()
+	}
+
+	cur := goedit.cursor.y + 1
+	if count == 0 {
+		count = 1
+	}
+
+	end := cur
+	switch key {
+	case '!':
+		end = cur + count - 1
+	case 'j', CURSOR_DOWN:
+		end = cur + count
+	case 'k', CURSOR_UP:
+		end = cur - count
+	case 'G':
+		end = goedit.numOfRows
+	case 'g':
+		if readKey() != 'g' {
+			return
+		}
+		end = 1
+	case '\'':
+		mark, ok := goedit.marks[readKey()]
+		if !ok {
+			editorError(exErr(20, "Mark not set", ""))
+			return
+		}
+		end = mark.y + 1
+	default:
+		return
+	}
+
+	if end > goedit.numOfRows {
+		end = goedit.numOfRows
+	}
+	if end < 1 {
+		end = 1
+	}
+
+	rng := "."
+	if end != cur {
+		rng = fmt.Sprintf(".,.%+d", end-cur)
+	}
+
+	cmd := editorPrompt(":" + rng + "!")
+	if cmd == "" {
+		return
+	}
+
+	if err := executeCommand(rng + "!" + cmd); err != nil {
+		editorError(err)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestExpandFileName(t *testing.T) {
+	defer func(filename, last string) { goedit.filename, goedit.lastShell = filename, last }(goedit.filename, goedit.lastShell)
+	goedit.filename, goedit.lastShell = "main.go", "ls"
+
+	for _, tt := range []struct{ cmd, want string }{
+		{"grep -n foo %", "grep -n foo main.go"},
+		{`grep -n 50\% %`, "grep -n 50% main.go"},
+		{"grep -n 'a!' *.go", "grep -n 'a!' *.go"},
+		{`grep -n 'a\!' *.go`, `grep -n 'a\!' *.go`},
+	} {
+		got, err := expandFileName(tt.cmd)
+		if err != nil || got != tt.want {
+			t.Errorf("expandFileName(%q) = %q %v, want %q", tt.cmd, got, err, tt.want)
+		}
+	}
+
+	if goedit.lastShell != "ls" {
+		t.Errorf("lastShell = %q, want it left alone", goedit.lastShell)
+	}
+
+	got, err := expandShellCommand("echo ! %")
+	if err != nil || got != "echo ls main.go" || goedit.lastShell != got {
+		t.Errorf("expandShellCommand = %q %v, lastShell %q", got, err, goedit.lastShell)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// substitution remembers the last :s so that a bare :s can repeat it.
+type substitution struct {
+	pattern     string
+	replacement string
+}
+
+// exSubstitute implements :[range]s/pattern/replacement/[gciI] [count].
+func exSubstitute(c *exCmd) error {
+	pattern, repl, flags := goedit.lastSub.pattern, goedit.lastSub.replacement, c.arg
+	if c.arg != "" && isSubstituteDelim(c.arg[0]) {
+		delim := c.arg[0]
+		var rest string
+		pattern, rest = splitDelimited(c.arg[1:], delim)
+		repl, flags = splitDelimited(rest, delim)
+		if pattern == "" {
+			pattern = goedit.search.query
+		}
+	}
+
+	if pattern == "" {
+		return exErr(35, "No previous regular expression", "")
+	}
+
+	global, confirm, caseFlag := false, false, ""
+	flags = strings.TrimSpace(flags)
+	for flags != "" && strings.ContainsRune("gciI&", rune(flags[0])) {
+		switch flags[0] {
+		case 'g':
+			global = true
+		case 'c':
+			confirm = true
+		case 'i':
+			caseFlag = `\c`
+		case 'I':
+			caseFlag = `\C`
+		}
+		flags = flags[1:]
+	}
+
+	if err := c.applyCount(strings.TrimSpace(flags)); err != nil {
+		return err
+	}
+
+	re, err := compilePattern(caseFlag + pattern)
+	if err != nil {
+		return err
+	}
+
+	goedit.lastSub = substitution{pattern: pattern, replacement: repl}
+	goedit.search.query = pattern
+
+	snapshot := undoSnapshot()
+	subs, lines, found := substituteLines(re, repl, c.line1-1, c.line2-1, global, confirm)
+	if !found {
+		return exErr(486, "Pattern not found", pattern)
+	}
+
+	if subs == 0 {
+		editorMessage("")
+		return nil
+	}
+
+	pushUndo(snapshot)
+	goedit.modifiyed = true
+	editorMessage(fmt.Sprintf("%d substitution%s on %d line%s", subs, plural(subs), lines, plural(lines)))
+
+	return nil
+}
+
+// substituteLines replaces matches of re on rows first to last, asking before
+// each one when confirm is set. It returns the number of substitutions, the
+// number of lines changed and whether re matched at all.
+func substituteLines(re *regexp.Regexp, repl string, first int, last int, global bool, confirm bool) (int, int, bool) {
+	n := 1
+	if global {
+		n = -1
+	}
+
+	subs, lines, found, quit := 0, 0, false, false
+	lastLine := -1
+	for y := first; y <= last && y < goedit.numOfRows && !quit; y++ {
+		orig := goedit.rows[y].text()
+		matches := re.FindAllStringSubmatchIndex(orig, n)
+		if len(matches) == 0 {
+			continue
+		}
+		found = true
+
+		out := strings.Builder{}
+		prev := 0
+		changed := false
+		for _, m := range matches {
+			replace := !quit
+			if confirm && !quit {
+				switch editorConfirmMatch(y, out.String()+orig[prev:], out.Len()+m[0]-prev, m[1]-m[0], repl) {
+				case 'n':
+					replace = false
+				case 'a':
+					confirm = false
+				case 'l':
+					quit = true
+				case 'q', '\x1b':
+					replace = false
+					quit = true
+				}
+			}
+
+			out.WriteString(orig[prev:m[0]])
+			if replace {
+				out.WriteString(expandReplacement(repl, orig, m))
+				subs++
+				changed = true
+			} else {
+				out.WriteString(orig[m[0]:m[1]])
+			}
+			prev = m[1]
+		}
+		out.WriteString(orig[prev:])
+
+		goedit.rows[y].setChars(out.String())
+		if changed {
+			lines++
+			lastLine = y
+		}
+	}
+
+	if lastLine != -1 {
+		editorGotoLine(lastLine + 1)
+	}
+
+	return subs, lines, found
+}
+
+// editorConfirmMatch shows row y as text with the match at byte offset start
+// highlighted and asks whether to replace it.
+func editorConfirmMatch(y int, text string, start int, length int, repl string) rune {
+	mode := goedit.mode
+	goedit.mode = NORMAL_MODE
+	defer func() {
+		goedit.mode = mode
+		goedit.curMatch = nil
+	}()
+
+	row := &goedit.rows[y]
+	row.setChars(text)
+	goedit.cursor = cursor{x: start, y: y}
+	goedit.curMatch = &matchRegion{y: y, start: cursorxToRx(*row, start), end: cursorxToRx(*row, start+length)}
+	editorMessage(fmt.Sprintf("replace with %s (y/n/a/q/l)?", repl))
+
+	for {
+		clearScreen()
+		switch key := readKey(); key {
+		case 'y', 'n', 'a', 'q', 'l', '\x1b':
+			return key
+		}
+	}
+}
+
+// expandReplacement builds the replacement for match m of src. It supports
+// & and \0 for the whole match, \1-\9 and $1-$9 for groups, \u and \l to
+// change the case of the next character, \U and \L to change the case of
+// what follows until \E or \e, and \t for a tab.
+func expandReplacement(repl string, src string, m []int) string {
+	out := strings.Builder{}
+	caseMode, oneShot := rune(0), rune(0)
+	write := func(s string) {
+		for _, r := range s {
+			switch {
+			case oneShot == 'u':
+				r = unicode.ToUpper(r)
+			case oneShot == 'l':
+				r = unicode.ToLower(r)
+			case caseMode == 'U':
+				r = unicode.ToUpper(r)
+			case caseMode == 'L':
+				r = unicode.ToLower(r)
+			}
+			oneShot = 0
+			out.WriteRune(r)
+		}
+	}
+	group := func(n int) string {
+		if 2*n+1 < len(m) && m[2*n] >= 0 {
+			return src[m[2*n]:m[2*n+1]]
+		}
+		return ""
+	}
+
+	runes := []rune(repl)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '&':
+			write(group(0))
+		case r == '$' && i+1 < len(runes) && runes[i+1] >= '0' && runes[i+1] <= '9':
+			i++
+			write(group(int(runes[i] - '0')))
+		case r == '$' && i+1 < len(runes) && runes[i+1] == '$':
+			i++
+			write("$")
+		case r == '\\' && i+1 < len(runes):
+			i++
+			switch n := runes[i]; {
+			case n >= '0' && n <= '9':
+				write(group(int(n - '0')))
+			case n == 'u' || n == 'l':
+				oneShot = n
+			case n == 'U' || n == 'L':
+				caseMode = n
+			case n == 'E' || n == 'e':
+				caseMode, oneShot = 0, 0
+			case n == 't':
+				write("\t")
+			default:
+				write(string(n))
+			}
+		default:
+			write(string(r))
+		}
+	}
+
+	return out.String()
+}
+
+// splitDelimited returns s up to the first delim not escaped by a backslash,
+// with "\delim" unescaped, and what follows the delimiter.
+func splitDelimited(s string, delim byte) (string, string) {
+	buf := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == delim:
+			buf.WriteByte(delim)
+			i++
+		case s[i] == '\\' && i+1 < len(s):
+			buf.WriteByte(s[i])
+			buf.WriteByte(s[i+1])
+			i++
+		case s[i] == delim:
+			return buf.String(), s[i+1:]
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+
+	return buf.String(), ""
+}
+
+func isSubstituteDelim(c byte) bool {
+	return c < 128 && !unicode.IsLetter(rune(c)) && !unicode.IsDigit(rune(c)) && !unicode.IsSpace(rune(c)) && c != '\\' && c != '"' && c != '|'
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+
+	return "s"
+}
@@ -0,0 +1,140 @@
+package main
+
+// undoState is a snapshot of the buffer taken before a change. seq numbers
+// the buffer contents it holds, so that undo and redo can tell whether they
+// return to what was last written.
+type undoState struct {
+	rows   []string
+	cursor cursor
+	seq    int
+}
+
+func undoSnapshot() undoState {
+	state := undoState{cursor: goedit.cursor, seq: goedit.undoSeq}
+	for _, r := range goedit.rows {
+		state.rows = append(state.rows, r.text())
+	}
+
+	return state
+}
+
+// pushUndo records state as the buffer before the latest change, dropping
+// the oldest changes beyond undolevels and anything that could be redone.
+// A negative undolevels keeps nothing, as in vim. The buffer is given a new
+// sequence number for the contents the change leaves it with.
+func pushUndo(state undoState) {
+	if goedit.undoDepth > 0 {
+		return
+	}
+
+	goedit.lastSeq++
+	goedit.undoSeq = goedit.lastSeq
+
+	levels := goedit.undoLevels
+	if levels < 0 {
+		levels = 0
+	}
+
+	goedit.undoStack = append(goedit.undoStack, state)
+	if n := len(goedit.undoStack) - levels; n > 0 {
+		goedit.undoStack = goedit.undoStack[n:]
+	}
+	goedit.redoStack = nil
+}
+
+// saveUndo records the current buffer so the change about to be made can be
+// undone as one step.
+func saveUndo() {
+	pushUndo(undoSnapshot())
+}
+
+// beginUndoGroup starts a command made of several changes, such as :g, that
+// should be undone as one step. It returns the state to pass to
+// endUndoGroup.
+func beginUndoGroup() undoState {
+	goedit.undoDepth++
+	return undoSnapshot()
+}
+
+func endUndoGroup(state undoState) {
+	goedit.undoDepth--
+	if goedit.undoDepth > 0 {
+		return
+	}
+
+	if !state.matchesBuffer() {
+		pushUndo(state)
+	}
+}
+
+// matchesBuffer reports whether the buffer holds the lines of state.
+func (state undoState) matchesBuffer() bool {
+	if len(state.rows) != goedit.numOfRows {
+		return false
+	}
+	for i := 0; i < goedit.numOfRows; i++ {
+		if state.rows[i] != goedit.rows[i].text() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// dropEmptyUndo removes the undo step saved for a command that left the
+// buffer as it was, such as i<Esc> or x on an empty line.
+func dropEmptyUndo() {
+	n := len(goedit.undoStack)
+	if n == 0 || goedit.undoDepth > 0 || !goedit.undoStack[n-1].matchesBuffer() {
+		return
+	}
+
+	goedit.undoSeq = goedit.undoStack[n-1].seq
+	goedit.undoStack = goedit.undoStack[:n-1]
+}
+
+// markSaved records that the buffer as it is now has been written.
+func markSaved() {
+	goedit.savedSeq = goedit.undoSeq
+	goedit.modifiyed = false
+}
+
+func restoreState(state undoState) {
+	goedit.rows = nil
+	goedit.numOfRows = 0
+	for i, text := range state.rows {
+		goedit.insertRow(i, text)
+	}
+
+	goedit.cursor = state.cursor
+	if goedit.cursor.y > goedit.numOfRows {
+		goedit.cursor.y = goedit.numOfRows
+	}
+	goedit.moveCursor(0)
+	goedit.undoSeq = state.seq
+	goedit.modifiyed = state.seq != goedit.savedSeq
+}
+
+func editorUndo() {
+	if len(goedit.undoStack) == 0 {
+		editorMessage("Already at oldest change")
+		return
+	}
+
+	state := goedit.undoStack[len(goedit.undoStack)-1]
+	goedit.undoStack = goedit.undoStack[:len(goedit.undoStack)-1]
+	goedit.redoStack = append(goedit.redoStack, undoSnapshot())
+	restoreState(state)
+}
+
+func editorRedo() {
+	if len(goedit.redoStack) == 0 {
+		editorMessage("Already at newest change")
+		return
+	}
+
+	state := goedit.redoStack[len(goedit.redoStack)-1]
+	goedit.redoStack = goedit.redoStack[:len(goedit.redoStack)-1]
+	goedit.undoStack = append(goedit.undoStack, undoSnapshot())
+	restoreState(state)
+}
@@ -0,0 +1,87 @@
+package main
+
+import "testing"
+
+func TestPushUndoLevels(t *testing.T) {
+	defer func(levels int) { goedit.undoLevels = levels }(goedit.undoLevels)
+
+	for _, tt := range []struct{ levels, pushes, want int }{
+		{100, 3, 3},
+		{2, 3, 2},
+		{0, 3, 0},
+		{-1, 3, 0},
+	} {
+		goedit.undoLevels = tt.levels
+		goedit.undoStack, goedit.redoStack = nil, nil
+		for i := 0; i < tt.pushes; i++ {
+			pushUndo(undoState{})
+		}
+		if len(goedit.undoStack) != tt.want {
+			t.Errorf("undolevels=%d: %d changes kept, want %d", tt.levels, len(goedit.undoStack), tt.want)
+		}
+	}
+}
+
+func TestUndoToSavedState(t *testing.T) {
+	defer func(levels int) { goedit.undoLevels = levels }(goedit.undoLevels)
+	defer func() { goedit.rows, goedit.numOfRows, goedit.cursor = nil, 0, cursor{} }()
+	goedit.undoLevels = 100
+	goedit.undoStack, goedit.redoStack = nil, nil
+	goedit.rows = testRows("a")
+	goedit.numOfRows = 1
+	markSaved()
+
+	change := func(text string) {
+		saveUndo()
+		goedit.rows[0].setChars(text)
+		goedit.modifiyed = true
+	}
+
+	change("b")
+	markSaved() // :w
+	change("c")
+
+	for _, step := range []struct {
+		undo     bool
+		text     string
+		modified bool
+	}{
+		{true, "b", false},
+		{true, "a", true},
+		{false, "b", false},
+		{false, "c", true},
+	} {
+		if step.undo {
+			editorUndo()
+		} else {
+			editorRedo()
+		}
+		if got := goedit.rows[0].text(); got != step.text || goedit.modifiyed != step.modified {
+			t.Errorf("undo %v: buffer %q modified %v, want %q %v", step.undo, got, goedit.modifiyed, step.text, step.modified)
+		}
+	}
+}
+
+func TestDropEmptyUndo(t *testing.T) {
+	defer func(levels int) { goedit.undoLevels = levels }(goedit.undoLevels)
+	defer func() { goedit.rows, goedit.numOfRows = nil, 0 }()
+	goedit.undoLevels = 100
+	goedit.undoStack, goedit.redoStack = nil, nil
+	goedit.rows = testRows("a")
+	goedit.numOfRows = 1
+	markSaved()
+
+	// i<Esc>
+	saveUndo()
+	dropEmptyUndo()
+	if len(goedit.undoStack) != 0 || goedit.undoSeq != goedit.savedSeq {
+		t.Errorf("after an empty insert: %d undo steps, seq %d saved %d", len(goedit.undoStack), goedit.undoSeq, goedit.savedSeq)
+	}
+
+	saveUndo()
+	goedit.rows[0].setChars("ab")
+	dropEmptyUndo()
+	if len(goedit.undoStack) != 1 {
+		t.Errorf("after an insert: %d undo steps, want 1", len(goedit.undoStack))
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strings"
+)
+
+// textWidth returns the number of screen columns left for text after the
+// line number gutter.
+func (e *editor) textWidth() int {
+	if w := e.width - e.lineNumOffSet; w > 0 {
+		return w
+	}
+
+	return 1
+}
+
+// wrapRow splits a row into screen lines, returning the render column each
+// screen line starts at. Continuation lines lose room to the showbreak
+// marker, and with linebreak set rows are broken after the last blank that
+// fits instead of at the screen edge.
+func (e *editor) wrapRow(r erow) []int {
+	segs := []int{0}
+	start := 0
+	avail := e.textWidth()
+	for start+avail < r.rsize {
+		end := start + avail
+		if e.linebreak {
+			if i := strings.LastIndexByte(r.render[start:end], ' '); i > 0 {
+				end = start + i + 1
+			}
+		}
+
+		segs = append(segs, end)
+		start = end
+		avail = e.textWidth() - len(e.showbreak)
+		if avail < 1 {
+			avail = 1
+		}
+	}
+
+	return segs
+}
+
+// segmentOf returns the index of the screen line holding render column rx.
+func segmentOf(segs []int, rx int) int {
+	i := 0
+	for i+1 < len(segs) && segs[i+1] <= rx {
+		i++
+	}
+
+	return i
+}
+
+// segmentCol returns the screen column, after the gutter, at which render
+// column rx of segment i is drawn.
+func (e *editor) segmentCol(segs []int, i int, rx int) int {
+	col := rx - segs[i]
+	if i > 0 {
+		col += len(e.showbreak)
+	}
+
+	return col
+}
+
+// cursorScreenRow returns the screen line the cursor is on when wrapping.
+func (e *editor) cursorScreenRow() int {
+	n := 0
+	for y := e.rowOffSet; y < e.cursor.y && y < e.numOfRows; y++ {
+		n += len(e.wrapRow(e.rows[y]))
+	}
+
+	if e.cursor.y < e.numOfRows {
+		n += segmentOf(e.wrapRow(e.rows[e.cursor.y]), e.rx)
+	}
+
+	return n
+}
+
+// cursorScreenCol returns the screen column of the cursor, after the gutter,
+// when wrapping.
+func (e *editor) cursorScreenCol() int {
+	if e.cursor.y >= e.numOfRows {
+		return 0
+	}
+
+	segs := e.wrapRow(e.rows[e.cursor.y])
+	return e.segmentCol(segs, segmentOf(segs, e.rx), e.rx)
+}
+
+// editorMoveScreenLine moves the cursor one screen line up or down, keeping
+// its screen column, for gj and gk. Without wrap it is the same as j and k.
+func editorMoveScreenLine(key rune) {
+	if !goedit.wrap || goedit.cursor.y >= goedit.numOfRows {
+		goedit.moveCursor(key)
+		return
+	}
+
+	y := goedit.cursor.y
+	segs := goedit.wrapRow(goedit.rows[y])
+	rx := cursorxToRx(goedit.rows[y], goedit.cursor.x)
+	seg := segmentOf(segs, rx)
+	col := goedit.segmentCol(segs, seg, rx)
+
+	switch key {
+	case CURSOR_DOWN:
+		if seg+1 < len(segs) {
+			seg++
+		} else if y+1 < goedit.numOfRows {
+			y++
+			seg = 0
+			segs = goedit.wrapRow(goedit.rows[y])
+		} else {
+			return
+		}
+	case CURSOR_UP:
+		if seg > 0 {
+			seg--
+		} else if y > 0 {
+			y--
+			segs = goedit.wrapRow(goedit.rows[y])
+			seg = len(segs) - 1
+		} else {
+			return
+		}
+	}
+
+	row := goedit.rows[y]
+	start := segs[seg]
+	rx = start + col - goedit.segmentCol(segs, seg, start)
+	if rx < start {
+		rx = start
+	}
+
+	if seg+1 < len(segs) && rx >= segs[seg+1] {
+		rx = segs[seg+1] - 1
+	}
+
+	goedit.cursor.y = y
+	goedit.cursor.x = cursorxToCx(row, rx)
+}